@@ -0,0 +1,91 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// AbortError is the error returned by ExchangeContext when the exchange was aborted by the context.
+type AbortError struct {
+	Err      error    // The context's error (context.Canceled or context.DeadlineExceeded).
+	InFlight []string // MIDs of the messages that were being transferred when the exchange was aborted.
+}
+
+func (e *AbortError) Error() string { return fmt.Sprintf("Exchange aborted: %s", e.Err) }
+
+// Unwrap returns the context's error.
+func (e *AbortError) Unwrap() error { return e.Err }
+
+// An AbortHandler is notified about the messages that were in flight when an exchange was aborted.
+//
+// The Session's MBoxHandler is used as AbortHandler if it implements this interface.
+type AbortHandler interface {
+	// SetAborted is called with the MIDs of the accepted messages (inbound and outbound)
+	// that was not completely transferred when the exchange was aborted.
+	SetAborted(MIDs ...string)
+}
+
+// The time we allow for sending the abort line to the remote.
+const abortTimeout = 10 * time.Second
+
+// watchContext unblocks any pending I/O on conn when ctx is done.
+//
+// The returned func must be called to release the resources, it returns when
+// conn is no longer accessed by the watcher.
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// Set a deadline in the past, causing all blocking reads and writes to return.
+			if err := conn.SetDeadline(time.Now()); err != nil {
+				conn.Close()
+			}
+		case <-done:
+		}
+	}()
+
+	return func() { close(done); <-stopped }
+}
+
+// abort notifies the handler and remote (if possible) that the exchange is aborted, and closes conn.
+func (s *Session) abort(conn net.Conn, cause error) error {
+	err := &AbortError{Err: cause}
+	for _, mid := range s.inFlight {
+		err.InFlight = append(err.InFlight, s.batchMembers(mid)...)
+	}
+	s.log.Printf("Exchange aborted: %s", cause)
+
+	if s.aborts != nil && len(err.InFlight) > 0 {
+		s.aborts.SetAborted(err.InFlight...)
+	}
+
+	s.event(Event{Type: EventError, Outbound: true, Line: "*** Session aborted", Err: err})
+	conn.SetDeadline(time.Now().Add(abortTimeout))
+	fmt.Fprintf(conn, "*** Session aborted\r\n")
+	conn.Close()
+
+	return err
+}
+
+func (s *Session) addInFlight(mid string) { s.inFlight = append(s.inFlight, mid) }
+
+func (s *Session) removeInFlight(mid string) {
+	for i, v := range s.inFlight {
+		if v == mid {
+			s.inFlight = append(s.inFlight[:i], s.inFlight[i+1:]...)
+			return
+		}
+	}
+}
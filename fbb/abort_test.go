@@ -0,0 +1,98 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchangeContextAbortInFlight(t *testing.T) {
+	client, srv := net.Pipe()
+	mbox := newMemMBox()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", mbox)
+		s.SetLogger(discardLogger)
+		_, err := s.ExchangeContext(ctx, client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprintf(srv, "FC EM TJKYEIMMHSRB 527 123 0\r")
+	fmt.Fprintf(srv, "F> 3b\r")
+	if line, _ := rd.ReadString('\r'); line != "FS +\r" {
+		t.Fatalf("Expected 'FS +', got '%s'", line)
+	}
+
+	// Stall the transfer, and hit the "stop button".
+	cancel()
+
+	line, _ := rd.ReadString('\n')
+	if !strings.HasPrefix(line, "***") {
+		t.Errorf("Expected abort line, got '%s'", line)
+	}
+
+	var err error
+	select {
+	case err = <-cerrs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exchange not aborted")
+	}
+
+	var abortErr *AbortError
+	if !errors.As(err, &abortErr) {
+		t.Fatalf("Expected AbortError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error to wrap context.Canceled")
+	}
+	if expect := []string{"TJKYEIMMHSRB"}; !reflect.DeepEqual(abortErr.InFlight, expect) {
+		t.Errorf("Expected in flight %v, got %v", expect, abortErr.InFlight)
+	}
+	if expect := []string{"TJKYEIMMHSRB"}; !reflect.DeepEqual(mbox.aborted, expect) {
+		t.Errorf("Expected handler to be notified about %v, got %v", expect, mbox.aborted)
+	}
+}
+
+func TestExchangeContextDeadline(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	go func() {
+		// Drain whatever the client sends, but never answer.
+		io.Copy(ioutil.Discard, srv)
+	}()
+
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+	s.SetLogger(discardLogger)
+	_, err := s.ExchangeContext(ctx, client)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// This file implements acknowledgements for personal messages (SID flag A).
+//
+// When both parties advertise the A flag, the receiver of a private message
+// generates an acknowledgement message addressed to the sender. The
+// acknowledgement is proposed in the receiver's next turn, and recognized by
+// the sender's session, which reports the delivery to its AckHandler instead
+// of delivering the acknowledgement as an ordinary message.
+//
+// Only acknowledgements of private messages sent to the acknowledging station
+// in the same session are recognized. Any other message looking like an
+// acknowledgement is delivered as an ordinary message.
+
+// The subject prefix of acknowledgement messages (same as FBB).
+const ackSubjectPrefix = "ACK:"
+
+// Acknowledgement is a confirmation that an outbound message was delivered to the remote's mailbox.
+type Acknowledgement struct {
+	MID       string    // The MID of the delivered message.
+	Recipient Address   // The station that acknowledged the delivery.
+	Delivered time.Time // When the message was delivered.
+}
+
+// An AckHandler is notified about delivery confirmations (acknowledgements) for outbound messages.
+//
+// The A flag is advertised in the handshake when the Session has an AckHandler. If the remote also
+// advertises it, acknowledgements are generated for received private messages, and acknowledgements
+// received from the remote are reported to the handler.
+//
+// If the Session's MBoxHandler implements this interface, it will be used by default.
+type AckHandler interface {
+	// SetDelivered is called when the remote confirms that the outbound message ack.MID was delivered.
+	SetDelivered(ack Acknowledgement) error
+}
+
+// SetAckHandler sets the AckHandler notified about delivered messages.
+//
+// A nil value disables acknowledgements.
+func (s *Session) SetAckHandler(h AckHandler) { s.acks = h }
+
+// acksEnabled returns true if acknowledgements was negotiated with the remote.
+func (s *Session) acksEnabled() bool { return s.acks != nil && s.remoteSID.Has(sAckForPM) }
+
+// ackSID returns the A flag if acknowledgements are enabled.
+func (s *Session) ackSID() string {
+	if s.acks == nil {
+		return ""
+	}
+	return sAckForPM
+}
+
+// NewAckMessage returns an acknowledgement message from mycall confirming that msg was delivered at the given time.
+//
+// The acknowledgement is addressed to the sender of msg. Its MID is derived from the MID of msg and mycall, so
+// that repeated acknowledgements of the same message are received only once.
+func NewAckMessage(mycall string, msg *Message, delivered time.Time) *Message {
+	ack := NewMessage(Private, mycall)
+	ack.Header.Set(HEADER_MID, derivedMID(ackSubjectPrefix+msg.MID()+"-"+strings.ToUpper(mycall)))
+	ack.AddTo(msg.From().String())
+
+	ack.SetSubject(truncateSubject(ackSubjectPrefix + msg.Subject()))
+
+	ack.SetBody(fmt.Sprintf(
+		"Your message to %s has been delivered.\n\nSubject: %s\nMid: %s\nDelivered: %s\n",
+		strings.ToUpper(mycall), msg.Subject(), msg.MID(), delivered.UTC().Format(DateLayout),
+	))
+	return ack
+}
+
+// ParseAck returns the acknowledgement carried by msg, if it is an acknowledgement message (see NewAckMessage).
+func ParseAck(msg *Message) (ack Acknowledgement, ok bool) {
+	if msg.Type() != Private || !strings.HasPrefix(msg.Subject(), ackSubjectPrefix) {
+		return ack, false
+	}
+
+	body, err := msg.Body()
+	if err != nil {
+		return ack, false
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Mid: "):
+			ack.MID = strings.TrimSpace(strings.TrimPrefix(line, "Mid: "))
+		case strings.HasPrefix(line, "Delivered: "):
+			ack.Delivered, _ = time.Parse(DateLayout, strings.TrimSpace(strings.TrimPrefix(line, "Delivered: ")))
+		}
+	}
+	if ack.MID == "" || ack.Delivered.IsZero() {
+		return ack, false
+	}
+
+	ack.Recipient = msg.From()
+	return ack, true
+}
+
+// handleAck processes msg if it is an acknowledgement, returning false if it should be delivered as an ordinary message.
+func (s *Session) handleAck(msg *Message) bool {
+	if !s.acksEnabled() {
+		return false
+	}
+
+	ack, ok := ParseAck(msg)
+	if !ok || !s.sentTo(ack.MID, ack.Recipient) {
+		return false
+	}
+
+	s.log.Printf("%s confirmed delivery of %s", ack.Recipient, ack.MID)
+	if err := s.acks.SetDelivered(ack); err != nil {
+		s.log.Printf("Unable to set %s delivered: %s", ack.MID, err)
+	}
+	return true
+}
+
+// queueAck queues an acknowledgement of the received message msg, to be proposed in our next turn.
+func (s *Session) queueAck(msg *Message) {
+	if !s.acksEnabled() || msg.Type() != Private {
+		return
+	}
+	if _, ok := ParseAck(msg); ok {
+		return // Never acknowledge (unmatched) acknowledgements
+	}
+	if s.ackMIDs == nil {
+		s.ackMIDs = make(map[string]bool)
+	}
+	ack := NewAckMessage(s.mycall, msg, time.Now())
+	s.ackMIDs[ack.MID()] = true
+	s.pendingAcks = append(s.pendingAcks, ack)
+}
+
+// trackOutbound records the receivers of the outbound message msg, so that acknowledgements of it can be recognized.
+func (s *Session) trackOutbound(msg *Message) {
+	if !s.acksEnabled() || msg.Type() != Private || s.isAck(msg.MID()) {
+		return
+	}
+	if s.outboundTo == nil {
+		s.outboundTo = make(map[string][]Address)
+	}
+	s.outboundTo[msg.MID()] = append(msg.To(), msg.Cc()...)
+}
+
+// setAckable marks the outbound message MID as sent, allowing its receivers to acknowledge it.
+func (s *Session) setAckable(MID string) {
+	receivers, ok := s.outboundTo[MID]
+	if !ok {
+		return
+	}
+	if s.ackable == nil {
+		s.ackable = make(map[string][]Address)
+	}
+	s.ackable[MID] = receivers
+}
+
+// sentTo returns true if the message identified by MID was sent to addr in this session.
+func (s *Session) sentTo(MID string, addr Address) bool {
+	for _, a := range s.ackable[MID] {
+		if strings.EqualFold(a.String(), addr.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAck returns true if MID identifies one of the acknowledgements generated in this session.
+func (s *Session) isAck(MID string) bool { return s.ackMIDs[MID] }
+
+// dropAck removes the acknowledgement identified by MID from the queue.
+//
+// Acknowledgements are proposed once (best effort), so this is done when it is sent, rejected or deferred.
+func (s *Session) dropAck(MID string) {
+	for i, m := range s.pendingAcks {
+		if m.MID() == MID {
+			s.pendingAcks = append(s.pendingAcks[:i], s.pendingAcks[i+1:]...)
+			return
+		}
+	}
+}
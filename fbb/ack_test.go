@@ -0,0 +1,158 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// ackMBox is a memMBox recording delivery confirmations.
+type ackMBox struct {
+	*memMBox
+	delivered []Acknowledgement
+}
+
+func (m *ackMBox) SetDelivered(ack Acknowledgement) error {
+	m.delivered = append(m.delivered, ack)
+	return nil
+}
+
+func TestAcknowledgements(t *testing.T) {
+	masterMsg, clientMsg := testMessage("LA5NTA", "N0CALL", 100), testMessage("N0CALL", "LA5NTA", 200)
+	masterBox := &ackMBox{memMBox: newMemMBox(masterMsg)}
+	clientBox := &ackMBox{memMBox: newMemMBox(clientMsg)}
+
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", masterBox)
+	master.SetLogger(discardLogger)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", clientBox)
+	client.SetLogger(discardLogger)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	if !master.RemoteInfo().Capabilities.Has(CapAck) || !client.RemoteInfo().Capabilities.Has(CapAck) {
+		t.Errorf("Expected A flag to be advertised by both parties")
+	}
+
+	for _, test := range []struct {
+		box  *ackMBox
+		mid  string
+		from string
+	}{
+		{masterBox, masterMsg.MID(), "N0CALL"},
+		{clientBox, clientMsg.MID(), "LA5NTA"},
+	} {
+		switch {
+		case len(test.box.delivered) != 1:
+			t.Errorf("Expected one delivery confirmation, got %v", test.box.delivered)
+		case test.box.delivered[0].MID != test.mid || test.box.delivered[0].Recipient.String() != test.from:
+			t.Errorf("Unexpected delivery confirmation %+v", test.box.delivered[0])
+		}
+
+		// The acknowledgement should not be delivered as an ordinary message
+		if len(test.box.in) != 1 {
+			t.Errorf("Expected one inbound message, got %d", len(test.box.in))
+		}
+	}
+
+	// Acknowledgements are not reported as sent messages
+	if stats := master.stats(); len(stats.Sent) != 1 || stats.Sent[0] != masterMsg.MID() {
+		t.Errorf("Unexpected sent messages %v", stats.Sent)
+	}
+}
+
+func TestUnmatchedAcknowledgements(t *testing.T) {
+	// A message to another station, acknowledged by the client (not a receiver)
+	other := testMessage("LA5NTA", "N1CALL", 100)
+	masterBox := &ackMBox{memMBox: newMemMBox(other)}
+
+	// An acknowledgement of a message never sent by the master
+	forged := NewAckMessage("N0CALL", testMessage("LA5NTA", "N0CALL", 200), time.Now())
+	clientBox := &ackMBox{memMBox: newMemMBox(forged)}
+
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", masterBox)
+	master.SetLogger(discardLogger)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", clientBox)
+	client.SetLogger(discardLogger)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	if len(masterBox.delivered) != 0 {
+		t.Errorf("Unexpected delivery confirmations %v", masterBox.delivered)
+	}
+	if len(masterBox.in) != 2 {
+		t.Errorf("Expected unmatched acknowledgements to be delivered as ordinary messages, got %d", len(masterBox.in))
+	}
+	if _, ok := masterBox.in[forged.MID()]; !ok {
+		t.Errorf("Forged acknowledgement not delivered as an ordinary message")
+	}
+}
+
+func TestAcknowledgementsNotNegotiated(t *testing.T) {
+	msg := testMessage("N0CALL", "LA5NTA", 100)
+	clientBox := &ackMBox{memMBox: newMemMBox(msg)}
+	masterBox := newMemMBox()
+
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", masterBox)
+	master.SetLogger(discardLogger)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", clientBox)
+	client.SetLogger(discardLogger)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	if client.RemoteInfo().Capabilities.Has(CapAck) {
+		t.Errorf("Unexpected A flag from remote without AckHandler")
+	}
+	if len(clientBox.delivered) != 0 || len(masterBox.in) != 1 {
+		t.Errorf("Unexpected acknowledgement when not negotiated")
+	}
+}
+
+func TestParseAck(t *testing.T) {
+	msg := testMessage("N0CALL", "LA5NTA", 100)
+	delivered := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+
+	ack, ok := ParseAck(NewAckMessage("la5nta", msg, delivered))
+	switch {
+	case !ok:
+		t.Fatalf("Acknowledgement not recognized")
+	case ack.MID != msg.MID() || !ack.Delivered.Equal(delivered) || ack.Recipient.String() != "LA5NTA":
+		t.Errorf("Unexpected acknowledgement %+v", ack)
+	}
+
+	if _, ok := ParseAck(msg); ok {
+		t.Errorf("Ordinary message parsed as acknowledgement")
+	}
+
+	// A message with an ACK: subject, but no acknowledgement body
+	other := NewMessage(Private, "N0CALL")
+	other.SetSubject("ACK: Meeting tomorrow")
+	other.SetBody("See you there")
+	if _, ok := ParseAck(other); ok {
+		t.Errorf("Message without acknowledgement body parsed as acknowledgement")
+	}
+
+	if ack := NewAckMessage("LA5NTA", msg, delivered); !strings.HasPrefix(ack.Subject(), "ACK:") || ack.Validate() != nil {
+		t.Errorf("Invalid acknowledgement message: %v", ack.Validate())
+	}
+
+	// Long subjects are truncated without splitting multi-byte characters
+	msg.SetSubject(strings.Repeat("æ", 100))
+	if ack := NewAckMessage("LA5NTA", msg, delivered); !utf8.ValidString(ack.Subject()) || ack.Validate() != nil {
+		t.Errorf("Invalid acknowledgement subject %q: %v", ack.Subject(), ack.Validate())
+	}
+}
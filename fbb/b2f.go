@@ -26,8 +26,13 @@ const (
 	MaxBlockSize            = 5
 
 	// Paclink-unix uses 250, protocol maximum is 255, but we use 125 to allow use of AX.25 links with a paclen of 128.
-	// TODO: Consider setting this dynamically.
+	//
+	// This is the default used when the write size is not advertised by the transport (see transport.WriteSizer)
+	// or set by Session.SetWriteSize.
 	MaxMsgLength = 125
+
+	// The largest data chunk we send when the write size allows it (same as Paclink-unix).
+	maxMsgLengthLimit = 250
 )
 
 const (
@@ -42,7 +47,7 @@ const (
 	cmdPropB = 'B'
 	cmdPropC = 'C' // Wl2k extended B2 message
 
-	cmdPropD = 'D' // Gzip compressed B2 message (GZIP_EXPERIMENT)
+	cmdPropD = 'D' // Gzip compressed B2 message (see GzipCodec)
 )
 
 const (
@@ -55,9 +60,14 @@ const (
 func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 	var sent map[string]bool
 
+	outbound, err := s.outbound()
+	if err != nil {
+		return
+	}
+
 	// Send outbound messages
-	if len(s.outbound()) > 0 {
-		sent, err = s.sendOutbound(rw)
+	if len(outbound) > 0 {
+		sent, err = s.sendOutbound(rw, outbound)
 		if err != nil {
 			return
 		}
@@ -66,13 +76,15 @@ func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 	// Report rejected now, they can safely be omitted even if an error occures
 	for mid, rej := range sent {
 		if rej {
-			s.h.SetSent(mid, rej)
+			if err = s.setSent(mid, rej); err != nil {
+				return
+			}
 			delete(sent, mid)
 		}
 	}
 
 	// If all messages was deferred/rejected, we should propose new messages
-	if len(sent) == 0 && len(s.outbound()) > 0 {
+	if len(sent) == 0 && len(outbound) > 0 {
 		return s.handleOutbound(rw)
 	}
 
@@ -82,11 +94,14 @@ func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 		// Turnover is implied
 	case s.remoteNoMsgs && len(sent) == 0:
 		s.pLog.Print(">FQ")
+		s.event(Event{Type: EventQuit, Outbound: true, Line: "FQ"})
 		fmt.Fprint(rw, "FQ\r")
 		quitSent = true
 		return // No need to check for remote error since we did not send any messages
 	default:
 		s.pLog.Print(">FF")
+		s.trafficStats.IdleTurnovers++
+		s.event(Event{Type: EventTurnover, Outbound: true, Line: "FF"})
 		fmt.Fprint(rw, "FF\r")
 	}
 
@@ -96,7 +111,9 @@ func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 	// turnover is 'F' or ';', so we use those to confirm the block
 	// was successfully received.
 	var p []byte
-	if p, err = s.rd.Peek(1); err != nil {
+	if err = s.skipNewlines(); err != nil {
+		return
+	} else if p, err = s.rd.Peek(1); err != nil {
 		return
 	} else if p[0] != 'F' && p[0] != ';' {
 		var line string
@@ -104,39 +121,40 @@ func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 		if err != nil {
 			return
 		}
-		err = fmt.Errorf("Unexpected response: '%s'", line)
+		err = protocolError(PhaseProposals, line, "Unexpected response: '%s'", line)
 		return
 	}
 
 	// Report successfully sent messages
+	s.trafficStats.Messages = append(s.trafficStats.Messages, s.tx.confirm()...)
 	for mid, rej := range sent {
-		s.h.SetSent(mid, rej)
-		if !rej {
-			s.trafficStats.Sent = append(s.trafficStats.Sent, mid)
+		if err = s.setSent(mid, rej); err != nil {
+			return
+		}
+		s.removeInFlight(mid)
+		for _, mid := range s.batchMembers(mid) {
+			if !rej && !s.isAck(mid) {
+				s.trafficStats.Sent = append(s.trafficStats.Sent, mid)
+				s.setAckable(mid)
+			}
 		}
 	}
 	return
 }
 
-func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err error) {
+func (s *Session) sendOutbound(rw io.ReadWriter, outbound []*Proposal) (sent map[string]bool, err error) {
 	sent = make(map[string]bool) // Use this to keep track of sent (rejected or not) mids.
 	var checksum int64
 
-	outbound := s.outbound()
 	if len(outbound) > MaxBlockSize {
 		outbound = outbound[0:MaxBlockSize]
 	}
 
 	for _, prop := range outbound {
-		sp := fmt.Sprintf("F%c %s %s %d %d %d",
-			prop.code,           // Proposal code
-			prop.msgType,        // Message type (1 or 2 alphanumeric)
-			prop.mid,            // Max 12 characters
-			prop.size,           // Uncompressed size of message
-			prop.compressedSize, // Compressed size of message
-			0)                   // ?
+		sp := s.proto.proposalLine(prop)
 
 		s.pLog.Printf(">%s", sp)
+		s.event(Event{Type: EventProposal, Outbound: true, Line: sp, Proposal: prop})
 		fmt.Fprintf(rw, "%s\r", sp)
 		for _, c := range sp {
 			checksum += int64(c)
@@ -145,8 +163,13 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 	}
 	checksum = (-checksum) & 0xff
 
-	s.log.Printf(`Sending checksum %02X`, checksum)
-	fmt.Fprintf(rw, "F> %02X\r", checksum)
+	prompt := "F>"
+	if s.proto.hasChecksum() {
+		s.log.Printf(`Sending checksum %02X`, checksum)
+		prompt = fmt.Sprintf("F> %02X", checksum)
+	}
+	s.event(Event{Type: EventProposalsEnd, Outbound: true, Line: prompt, Proposals: outbound})
+	fmt.Fprintf(rw, "%s\r", prompt)
 
 	var reply string
 	for reply == "" {
@@ -154,18 +177,21 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 		switch {
 		case err != nil:
 			return sent, err
+		case line == "":
+			continue // Trailing line break (basic ascii protocol)
 		case strings.HasPrefix(line, "FS "):
 			reply = line // The expected proposal answer
 		case strings.HasPrefix(line, ";"):
 			continue // Ignore comment
 		default:
-			return sent, fmt.Errorf("Expected proposal answer from remote. Got: '%s'", reply)
+			return sent, protocolError(PhaseProposals, line, "Expected proposal answer from remote. Got: '%s'", line)
 		}
 	}
 
 	if err = parseProposalAnswer(reply, outbound, s.log); err != nil {
-		return sent, fmt.Errorf("Unable to parse proposal answer: %s", err)
+		return sent, protocolError(PhaseProposals, reply, "Unable to parse proposal answer: %s", err)
 	}
+	s.event(Event{Type: EventProposalAnswer, Line: reply, Proposals: outbound})
 
 	if len(outbound) == 0 {
 		return
@@ -176,16 +202,30 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 		defer r.SetRobust(true)
 	}
 
+	s.tx.reset(rw)
 	for _, prop := range outbound {
 		switch prop.answer {
 		case Defer:
-			s.h.SetDeferred(prop.mid)
+			if err = s.setDeferred(prop.mid); err != nil {
+				return
+			}
 		case Reject:
 			sent[prop.mid] = true
 		case Accept:
-			if err = s.writeCompressed(rw, prop); err != nil {
+			s.addInFlight(prop.mid)
+			s.event(Event{Type: EventTransferStarted, Outbound: true, Proposal: prop})
+			started := time.Now()
+			if prop.code == BasicProposal {
+				err = s.writeBasic(rw, prop)
+			} else {
+				err = s.writeCompressed(rw, prop)
+			}
+			if err != nil {
 				return
 			}
+			s.tx.add(prop, started)
+			s.event(Event{Type: EventTransferCompleted, Outbound: true, Proposal: prop})
+			s.spend(prop)
 			sent[prop.mid] = false
 		}
 	}
@@ -212,11 +252,11 @@ Loop:
 
 		// The line should be prefixed F? (? is the command character)
 		if len(line) < 2 || line[0] != 'F' {
-			return false, fmt.Errorf("Got unexpected protocol line: '%s'", line)
+			return false, protocolError(PhaseProposals, line, "Got unexpected protocol line: '%s'", line)
 		}
 
-		switch line[:2] {
-		case "FA", "FB", "FC", "FD": // Proposals
+		switch cmd := line[:2]; {
+		case isProposalCode(PropCode(line[1])): // Proposals (FA, FB or a registered codec, see RegisterCodec)
 			for _, c := range line {
 				ourChecksum += int64(c)
 			}
@@ -224,25 +264,34 @@ Loop:
 
 			prop := new(Proposal)
 			if err = parseProposal(line, prop); err != nil {
-				err = errors.New(`Unable to parse proposal: ` + err.Error())
+				err = protocolError(PhaseProposals, line, "Unable to parse proposal: %s", err)
 				return
 			}
+			prop.compV0 = s.proto == protoFBBComp0
 			proposals = append(proposals, prop)
+			s.event(Event{Type: EventProposal, Line: line, Proposal: prop})
 
-		case "FF": // No more messages
+		case cmd == "FF": // No more messages
+			s.trafficStats.IdleTurnovers++
+			s.event(Event{Type: EventTurnover, Line: line})
 			break Loop
 
-		case "FQ": // Quit
+		case cmd == "FQ": // Quit
+			s.event(Event{Type: EventQuit, Line: line})
 			quitReceived = true
 			break Loop
 
-		case "F>": // Prompt (end of proposal block)
-			// Verify checksum
+		case cmd == "F>": // Prompt (end of proposal block)
+			s.event(Event{Type: EventProposalsEnd, Line: line, Proposals: proposals})
+
+			// Verify checksum (not sent with the basic ascii and compressed v0 protocols)
 			ourChecksum = (-ourChecksum) & 0xff
-			their, _ := strconv.ParseInt(line[3:], 16, 64)
-			if their != ourChecksum {
-				err = errors.New(fmt.Sprintf(`Checksum error (%d-%d)`, ourChecksum, their))
-				return
+			if len(line) > 3 {
+				their, _ := strconv.ParseInt(line[3:], 16, 64)
+				if their != ourChecksum {
+					err = &ChecksumError{Err: protocolError(PhaseProposals, line, "Checksum error (%d-%d)", ourChecksum, their)}
+					return
+				}
 			}
 
 			// If we didn't get any proposals, return
@@ -264,12 +313,12 @@ Loop:
 			// Continue receiving proposals if all where rejected/deferred
 			return s.handleInbound(rw)
 		default: //TODO: Ignore?
-			return false, fmt.Errorf("Unknown protocol command %c", line[1])
+			return false, protocolError(PhaseProposals, line, "Unknown protocol command %c", line[1])
 		}
 	}
 
 	if quitReceived && nAccepted > 0 {
-		return true, errors.New("Got quit command when inbound proposals were pending")
+		return true, protocolError(PhaseProposals, "FQ", "Got quit command when inbound proposals were pending")
 	}
 
 	// Fetch and decompress accepted
@@ -280,54 +329,117 @@ Loop:
 		}
 		s.remoteNoMsgs = false
 
-		var msg *Message
-		if err = s.readCompressed(rw, prop); err != nil {
+		var msgs []*Message
+		s.event(Event{Type: EventTransferStarted, Proposal: prop})
+		if prop.code == BasicProposal {
+			err = s.readBasic(prop)
+		} else {
+			err = s.readCompressed(rw, prop)
+		}
+		if err != nil {
 			return
-		} else if msg, err = prop.Message(); err != nil {
+		}
+		stats := newMessageStats(prop, false, s.rxStarted, time.Now())
+		if msgs, err = s.inboundMessages(prop); err != nil {
 			return
 		}
 
-		if err = s.h.ProcessInbound(msg); err != nil {
+		if err = s.processInbound(prop, msgs); err != nil {
 			return
 		}
-		s.trafficStats.Received = append(s.trafficStats.Received, prop.MID())
+		s.removeInFlight(prop.MID())
+		if !prop.isBatch() {
+			s.trafficStats.Received = append(s.trafficStats.Received, prop.MID())
+		}
+		s.trafficStats.Messages = append(s.trafficStats.Messages, stats)
+		s.spend(prop)
+		s.event(Event{Type: EventTransferCompleted, Proposal: prop})
 	}
 
 	return
 }
 
+// processInbound delivers the received message(s) of a proposal to the mailbox handler.
+//
+// Attachments spooled to disk (see SetSpool) are removed when done.
+func (s *Session) processInbound(prop *Proposal, msgs []*Message) error {
+	defer func() {
+		for _, msg := range msgs {
+			msg.removeSpooled()
+		}
+	}()
+
+	for _, msg := range msgs {
+		if !s.handleAck(msg) {
+			if err := s.h.ProcessInbound(msg); err != nil {
+				return err
+			}
+			s.queueAck(msg)
+		}
+		if s.bids != nil && msg.IsBulletin() {
+			if err := s.bids.AddBID(msg.BID()); err != nil {
+				s.log.Printf("Unable to add %s to BID history: %s", msg.BID(), err)
+			}
+		}
+		if prop.isBatch() {
+			s.trafficStats.Received = append(s.trafficStats.Received, msg.MID())
+		}
+	}
+	return nil
+}
+
 // The B2F protocol does not support offsets larger than 6 digits, the author of the protocol
 // seems to have thrown away the idea of supporting transfer of fragmented messages.
 //
-// If we ever want to support requests of message with offset, we must guard against asking for
-// offsets > 999999. RMS Express does not do this (in Winmor P2P anyway), we must avoid that pitfall.
+// When requesting a message with offset, we must guard against asking for offsets > 999999.
+// RMS Express does not do this (in Winmor P2P anyway), we must avoid that pitfall.
 func (s *Session) writeProposalsAnswer(rw io.ReadWriter, proposals []*Proposal) (nAccepted int, err error) {
-	answers := make([]byte, len(proposals))
+	var answers bytes.Buffer
 
 	seen := make(map[string]bool)
+	var acceptedBytes int // Bytes accepted in this block (see Budget)
 
-	for i, prop := range proposals {
+	for _, prop := range proposals {
 		if seen[prop.MID()] {
 			// Radio Only gateways will sometimes send multiple proposals for the same MID in the same batch.
 			// Instead of rejecting them right away, let's defer the dups until we know we have sucessfully received at least one of the copies.
 			s.log.Printf("Defering duplicate message %s", prop.MID())
 			prop.answer = Defer
-		} else if prop.code != Wl2kProposal && prop.code != GzipProposal {
+		} else if !s.proto.supports(prop) || (prop.isBatch() && !s.batchEnabled()) {
 			s.log.Printf("Defering %s (unsupported format)", prop.MID())
 			prop.answer = Defer
 		} else if s.h == nil {
 			s.log.Printf("Defering %s (missing handler)", prop.MID())
 			prop.answer = Defer
-		} else if prop.answer = s.h.GetInboundAnswer(*prop); prop.answer == Accept {
-			s.log.Printf("Accepting %s", prop.MID()) //TODO: Remove?
+		} else if s.bids != nil && s.bids.HasBID(prop.MID()) {
+			s.log.Printf("Rejecting %s (BID seen before)", prop.MID())
+			prop.answer = Reject
+		} else if prop.answer = s.h.GetInboundAnswer(*prop); prop.answer == Accept && !s.withinBudget(acceptedBytes+prop.transferSize(), nAccepted+1) {
+			s.log.Printf("Defering %s (session budget spent)", prop.MID())
+			prop.answer = Defer
+			s.trafficStats.InboundLeft = appendUnique(s.trafficStats.InboundLeft, prop.MID())
+		} else if prop.answer == Accept {
 			nAccepted++
+			acceptedBytes += prop.transferSize()
+			s.addInFlight(prop.MID())
+			if prop.offset = s.resumeOffset(prop); prop.offset > 0 {
+				s.log.Printf("Accepting %s at offset %d", prop.MID(), prop.offset)
+			} else {
+				s.log.Printf("Accepting %s", prop.MID()) //TODO: Remove?
+			}
 		}
 
 		seen[prop.MID()] = true
-		answers[i] = byte(prop.answer)
+		if prop.answer == Accept && prop.offset > 0 {
+			fmt.Fprintf(&answers, "!%d", prop.offset)
+		} else {
+			answers.WriteByte(byte(prop.answer))
+		}
 	}
 
-	_, err = fmt.Fprintf(rw, "FS %s\r", answers)
+	line := "FS " + answers.String()
+	s.event(Event{Type: EventProposalAnswer, Outbound: true, Line: line, Proposals: proposals})
+	_, err = fmt.Fprintf(rw, "%s\r", line)
 	return
 }
 
@@ -361,22 +473,26 @@ func parseProposalAnswer(str string, props []*Proposal, l *log.Logger) error {
 			}
 			prop.answer = Defer
 		case 'A', 'a', '!':
-			idx := strings.LastIndexAny(str, "0123456789")
-			if idx < 0 {
+			// The offset is the run of digits following the answer character
+			n := 0
+			for n < len(str) && str[n] >= '0' && str[n] <= '9' {
+				n++
+			}
+			if n == 0 {
 				return errors.New("Got offset request without offset index")
 			}
-			prop.answer = Accept // Offset is not implemented as a ProposalAnswer
-			prop.offset, _ = strconv.Atoi(str[:idx+1])
-			str = str[idx+1:]
+			prop.answer = Accept // The offset is kept in the proposal, see writeCompressed
+			prop.offset, _ = strconv.Atoi(str[:n])
+			str = str[n:]
 
 			if prop.offset > ProtocolOffsetSizeLimit { // RMS Express does this (in Winmor P2P for sure)
-				prop.offset = 0
 				if l != nil {
 					l.Printf(
 						"Remote requested %s at offset %d which exceeds the binary protocol offset limit. Ignoring offset.",
 						prop.MID(), prop.offset,
 					)
 				}
+				prop.offset = 0
 			} else if l != nil {
 				l.Printf("Remote accepted %s at offset %d", prop.MID(), prop.offset)
 			}
@@ -388,13 +504,15 @@ func parseProposalAnswer(str string, props []*Proposal, l *log.Logger) error {
 }
 
 func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
-	s.log.Printf("Transmitting [%s] [offset %d]", p.title, p.offset)
-
-	if p.code == GzipProposal {
-		s.log.Println("GZIP_EXPERIMENT:", "Transmitting gzip compressed message.")
+	if p.offset > 0 && (p.offset < compressedHeaderLen || p.offset >= p.compressedSize) {
+		s.log.Printf("Invalid offset %d requested for %s. Ignoring offset.", p.offset, p.MID())
+		p.offset = 0
 	}
 
-	writer := bufio.NewWriter(rw)
+	s.log.Printf("Transmitting [%s] [offset %d]", p.title, p.offset)
+
+	writeSize := s.optimalWriteSize(rw)
+	writer := bufio.NewWriterSize(s.tx.writer(rw), writeSize)
 
 	var (
 		title    = mime.QEncoding.Encode("utf-8", p.title) // Word-encode the title since this field must be ASCII-only
@@ -410,11 +528,20 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	writer.WriteByte(_CHRNUL)
 	writer.Flush()
 
-	if p.compressedSize < 6 { // lzhuf's smallest valid length (empty)
+	if p.compressedSize < 6 && !p.compV0 { // lzhuf's smallest valid length (empty)
 		return errors.New(`Invalid compressed data`)
 	}
 
-	buffer := bytes.NewBuffer(p.compressedData[p.offset:])
+	// When resuming, the compressed header (CRC16 and size) is always sent before the data
+	// at the requested offset. This is how the FBB protocol describes resume for version 1.
+	var buffer *bytes.Buffer
+	if p.offset > 0 {
+		buffer = bytes.NewBuffer(nil)
+		buffer.Write(p.compressedData[:compressedHeaderLen])
+		buffer.Write(p.compressedData[p.offset:])
+	} else {
+		buffer = bytes.NewBuffer(p.compressedData)
+	}
 
 	// Update Status of message transfer every 250ms
 	statusTicker := time.NewTicker(250 * time.Millisecond)
@@ -460,13 +587,30 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	}()
 	defer func() { close(statusDone) }()
 
-	// Data (in chunks of max 250)
+	// Data (in chunks of max 250). Each chunk is prefixed by STX and length, and as
+	// many chunks as possible are packed into each write to the connection.
+	maxLen := writeSize - 2
+	switch {
+	case maxLen > maxMsgLengthLimit:
+		maxLen = maxMsgLengthLimit
+	case maxLen < 1:
+		maxLen = 1
+	}
+
 	for buffer.Len() > 0 {
-		msgLen := MaxMsgLength
-		if buffer.Len() < MaxMsgLength {
+		msgLen := maxLen
+		if buffer.Len() < maxLen {
 			msgLen = buffer.Len()
 		}
 
+		// Flush if this chunk does not fit in the current write
+		if n := writer.Buffered(); n > 0 && n+msgLen+2 > writeSize {
+			if err = writer.Flush(); err != nil {
+				return err
+			}
+			s.tx.poll()
+		}
+
 		if _, err = writer.Write([]byte{_CHRSTX, byte(msgLen)}); err != nil {
 			return err
 		}
@@ -478,10 +622,10 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 			}
 			checksum += int64(c)
 		}
+	}
 
-		if err = writer.Flush(); err != nil {
-			return err
-		}
+	if err = writer.Flush(); err != nil {
+		return err
 	}
 
 	// Checksum
@@ -504,20 +648,32 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	var (
 		ourChecksum int
 		buf         bytes.Buffer
+		header      []byte // The compressed header re-sent by the remote when resuming
 	)
 
+	if p.offset > 0 {
+		buf.Write(p.compressedData[:p.offset])
+		header = p.compressedData[:compressedHeaderLen]
+	}
+
 	var c byte
 	if c, err = s.rd.ReadByte(); err != nil {
 		return
 	}
+	s.rxStarted = time.Now()
 	switch c {
 	case _CHRSOH:
 		// what we expected...
 	case '*':
-		line, _ := s.nextLine()
-		return errors.New(fmt.Sprintf(`Got error from CMS: %s`, line))
+		line, _ := s.nextLineRemoteErr(false)
+		err = errLine("*" + line)
+		if err == nil {
+			err = &RemoteError{Line: "*" + line}
+		}
+		s.event(Event{Type: EventError, Line: "*" + line, Err: err})
+		return err
 	default:
-		return errors.New(fmt.Sprintf(`First byte not as expected, got %d`, int(c)))
+		return protocolError(PhaseTransfer, "", "First byte not as expected, got %d", int(c))
 	}
 
 	if c, err = s.rd.ReadByte(); err != nil {
@@ -528,7 +684,7 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	// Read proposal title.
 	title, err := s.rd.ReadString(_CHRNUL)
 	if err != nil {
-		return errors.New(`Unable to parse title: ` + err.Error())
+		return protocolError(PhaseTransfer, "", "Unable to parse title: %w", err)
 	}
 	title = title[:len(title)-1] // Remove _CHRNUL
 
@@ -539,7 +695,7 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	// Read offset part
 	var offsetStr string
 	if offsetStr, err = s.rd.ReadString(_CHRNUL); err != nil {
-		return errors.New(`Unable to parse offset: ` + err.Error())
+		return protocolError(PhaseTransfer, "", "Unable to parse offset: %w", err)
 	} else {
 		offsetStr = offsetStr[:len(offsetStr)-1]
 	}
@@ -547,24 +703,20 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	// Check overall length of header
 	actualHeaderLength := (len(title) + len(offsetStr)) + 2
 	if headerLength != actualHeaderLength {
-		return errors.New(fmt.Sprintf(`Header length mismatch: expected %d, got %d`, headerLength, actualHeaderLength))
+		return protocolError(PhaseTransfer, "", "Header length mismatch: expected %d, got %d", headerLength, actualHeaderLength)
 	}
 
 	// Parse offset as integer (and do some sanity checks)
 	offset, err := strconv.Atoi(offsetStr)
 	switch {
 	case err != nil:
-		return fmt.Errorf("Offset header not parseable as integer: %s", err)
+		return protocolError(PhaseTransfer, "", "Offset header not parseable as integer: %s", err)
 	case offset != p.offset:
-		return fmt.Errorf(`Expected offset %d, got %d`, p.offset, offset)
+		return protocolError(PhaseTransfer, "", "Expected offset %d, got %d", p.offset, offset)
 	}
 
 	s.log.Printf("Receiving [%s] [offset %d]", p.title, p.offset)
 
-	if p.code == GzipProposal {
-		s.log.Println("GZIP_EXPERIMENT:", "Receiving gzip compressed message.")
-	}
-
 	statusUpdate := make(chan struct{})
 	go func() {
 		for {
@@ -594,6 +746,7 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 		updateStatus()
 		c, err = s.rd.ReadByte()
 		if err != nil {
+			s.savePartial(p, buf.Bytes())
 			return err
 		}
 
@@ -607,10 +760,19 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 			for i := 0; i < length; i++ {
 				c, err = s.rd.ReadByte()
 				if err != nil {
+					s.savePartial(p, buf.Bytes())
 					return
 				}
-				buf.WriteByte(c)
 				ourChecksum = (ourChecksum + int(c)) % 256
+				if len(header) > 0 {
+					if c != header[0] {
+						s.deletePartial(p)
+						return protocolError(PhaseTransfer, "", "Compressed header mismatch when resuming transfer")
+					}
+					header = header[1:]
+					continue
+				}
+				buf.WriteByte(c)
 				if i%10 == 0 {
 					updateStatus()
 				}
@@ -619,15 +781,59 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 			c, _ = s.rd.ReadByte()
 			ourChecksum = (ourChecksum + int(c)) % 256
 			if ourChecksum != 0 {
-				return errors.New(`Bad checksum`)
-			} else if p.compressedSize != buf.Len() {
-				return errors.New(`Length mismatch after EOT`)
+				s.deletePartial(p)
+				return &ChecksumError{MID: p.MID()}
+			} else if p.compressedSize >= 0 && p.compressedSize != buf.Len() {
+				s.deletePartial(p)
+				return protocolError(PhaseTransfer, "", "Length mismatch after EOT")
 			} else {
 				p.compressedData = buf.Bytes()
+				p.compressedSize = buf.Len()
+				s.deletePartial(p)
 			}
 			return
 		default:
-			return errors.New(`Unexpected byte in compressed stream: ` + string(c))
+			return protocolError(PhaseTransfer, "", "Unexpected byte in compressed stream: %q", c)
+		}
+	}
+}
+
+// setSent marks the message identified by MID as sent (or rejected).
+func (s *Session) setSent(MID string, rejected bool) error {
+	if mids, ok := s.batches[MID]; ok {
+		for _, mid := range mids {
+			if err := s.setSent(mid, rejected); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.isAck(MID) {
+		s.dropAck(MID)
+		return nil
+	}
+	if err := s.h.SetSent(s.ctx, MID, rejected); err != nil {
+		return fmt.Errorf("Unable to mark %s as sent: %w", MID, err)
+	}
+	return nil
+}
+
+// setDeferred marks the message identified by MID as deferred.
+func (s *Session) setDeferred(MID string) error {
+	if mids, ok := s.batches[MID]; ok {
+		for _, mid := range mids {
+			if err := s.setDeferred(mid); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+	if s.isAck(MID) {
+		s.dropAck(MID)
+		return nil
 	}
+	if err := s.h.SetDeferred(s.ctx, MID); err != nil {
+		return fmt.Errorf("Unable to defer %s: %w", MID, err)
+	}
+	return nil
 }
@@ -28,6 +28,13 @@ func TestParseProposalAnswer(t *testing.T) {
 			&Proposal{answer: Reject}, // -
 			&Proposal{answer: Accept}, // +
 		},
+		"FS !1234!5678+A9-": []*Proposal{
+			&Proposal{answer: Accept, offset: 1234}, // !1234
+			&Proposal{answer: Accept, offset: 5678}, // !5678
+			&Proposal{answer: Accept},               // +
+			&Proposal{answer: Accept, offset: 9},    // A9
+			&Proposal{answer: Reject},               // -
+		},
 	}
 
 	for input, expected := range tests {
@@ -40,6 +47,9 @@ func TestParseProposalAnswer(t *testing.T) {
 			if exp.answer != got[i].answer {
 				t.Errorf("Test %d: expected %c got %c", i, exp.answer, got[i].answer)
 			}
+			if exp.offset != got[i].offset {
+				t.Errorf("Test %d: expected offset %d got %d", i, exp.offset, got[i].offset)
+			}
 		}
 	}
 }
@@ -0,0 +1,303 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/la5nta/wl2k-go/lzhuf"
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// This file implements the legacy FBB forwarding protocols (basic ascii and compressed v0/v1),
+// used when the remote does not support B2F.
+//
+// See docs/F6FBB-B2F/protocole.html for details.
+
+// forwardProtocol is the forwarding protocol negotiated with the remote.
+type forwardProtocol int
+
+const (
+	protoB2F      forwardProtocol = iota // FBB compressed protocol v2 (B2F)
+	protoFBBComp1                        // FBB compressed protocol v1
+	protoFBBComp0                        // FBB compressed protocol v0
+	protoFBBBasic                        // FBB basic ascii protocol
+)
+
+// The character terminating a message in the basic ascii protocol (Ctrl-Z).
+const _CHRSUB = 0x1a
+
+// negotiateProtocol returns the most capable forwarding protocol supported by both parties.
+func negotiateProtocol(remote sid) (forwardProtocol, error) {
+	switch {
+	case remote.Has(sFBComp2):
+		return protoB2F, nil
+	case !remote.Has(sFBBasic):
+		// A SID with B and no F should be treated as having neither.
+		return 0, ErrNoFB2
+	case remote.Has(sFBComp1):
+		return protoFBBComp1, nil
+	case remote.Has(sFBComp0):
+		return protoFBBComp0, nil
+	default:
+		return protoFBBBasic, nil
+	}
+}
+
+// sid returns the SID codes we advertise when the given protocol has been negotiated.
+func (p forwardProtocol) sid() string {
+	switch p {
+	case protoFBBComp1:
+		return sFBComp1 + sFBBasic + sHL + sMID + sBID
+	case protoFBBComp0:
+		return sFBComp0 + sFBBasic + sHL + sMID + sBID
+	case protoFBBBasic:
+		return sFBBasic + sHL + sMID + sBID
+	default:
+		return localSID
+	}
+}
+
+// propCode returns the proposal code used for outbound messages with this protocol.
+func (p forwardProtocol) propCode() PropCode {
+	switch p {
+	case protoFBBComp1, protoFBBComp0:
+		return AsciiProposal
+	case protoFBBBasic:
+		return BasicProposal
+	default:
+		return Wl2kProposal
+	}
+}
+
+// supports returns true if the given inbound proposal can be received with this protocol.
+func (p forwardProtocol) supports(prop *Proposal) bool {
+	switch p {
+	case protoFBBComp1, protoFBBComp0:
+		return prop.code == AsciiProposal && isFBBMsgType(prop.msgType) // FB is a binary file in the compressed protocols.
+	case protoFBBBasic:
+		return prop.code == BasicProposal && isFBBMsgType(prop.msgType)
+	default:
+		_, ok := LookupCodec(prop.code)
+		return ok
+	}
+}
+
+// isFBBMsgType returns true if the FBB message type is supported (private message or bulletin).
+func isFBBMsgType(t string) bool { return t == "P" || t == "B" }
+
+// hasChecksum returns true if the proposal block is terminated by a checksum.
+func (p forwardProtocol) hasChecksum() bool { return p == protoB2F || p == protoFBBComp1 }
+
+// FBB proposals looks like: FB P F6FBB FC1GHV FC1MVP 24657_F6FBB 1345
+//
+// FA is the compressed equivalent. Version 1 of the compressed protocol allows extra fields.
+func parseFBBProposal(line string, prop *Proposal) error {
+	parts := strings.Fields(line[2:])
+	if len(parts) < 6 {
+		return errors.New(`Malformed proposal: ` + line)
+	}
+
+	prop.msgType, prop.from, prop.at, prop.to, prop.mid = parts[0], parts[1], parts[2], parts[3], parts[4]
+	if err := ValidateMID(prop.mid); err != nil {
+		return fmt.Errorf("Invalid BID %q: %w", prop.mid, err)
+	}
+
+	size, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return fmt.Errorf(`Malformed proposal size: %s`, parts[5])
+	}
+	prop.size = size
+	prop.compressedSize = -1 // Unknown until received
+	if prop.code == BasicProposal {
+		prop.compressedSize = size
+	}
+
+	return nil
+}
+
+// fbbProposal returns a FBB (non-B2) proposal of the message.
+//
+// The FBB message format can only express a single recipient and has no support for attachments.
+func (m *Message) fbbProposal(code PropCode, compV0 bool) (*Proposal, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	receivers := m.Receivers()
+	switch {
+	case len(receivers) != 1:
+		return nil, errors.New("FBB messages must have exactly one recipient")
+	case receivers[0].Proto != "" || m.From().Proto != "":
+		return nil, errors.New("FBB messages must be addressed to and from call signs")
+	case len(m.Files()) > 0:
+		return nil, errors.New("FBB messages can not hold attachments")
+	}
+
+	// FBB uses CR as line terminator
+	text := bytes.Replace(m.body, []byte("\r\n"), []byte("\r"), -1)
+
+	msgType := "P"
+	if m.IsBulletin() {
+		msgType = "B"
+	}
+
+	prop := &Proposal{
+		code:    code,
+		msgType: msgType,
+		mid:     m.MID(),
+		title:   m.Subject(),
+		size:    len(text),
+		from:    m.From().Addr,
+		at:      m.Header.Get(HEADER_AT),
+		to:      receivers[0].Addr,
+		compV0:  compV0,
+	}
+	if prop.title == `` {
+		prop.title = `No title`
+	}
+
+	switch code {
+	case BasicProposal:
+		prop.compressedData = text
+	case AsciiProposal:
+		var buf bytes.Buffer
+		z := lzhuf.NewWriter(&buf, !compV0)
+		z.Write(text)
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
+		prop.compressedData = buf.Bytes()
+	default:
+		return nil, fmt.Errorf("Not a FBB proposal code '%c'", code)
+	}
+	prop.compressedSize = len(prop.compressedData)
+
+	return prop, nil
+}
+
+// fbbMessage converts the (decompressed) text of a FBB message to a Message.
+func (p *Proposal) fbbMessage(text []byte) (*Message, error) {
+	msg := &Message{Header: make(Header)}
+	msg.Header.Set(HEADER_MID, p.mid)
+	msg.Header.Set(HEADER_TYPE, string(Private))
+	if p.IsBulletin() {
+		msg.Header.Set(HEADER_TYPE, Bulletin)
+	}
+	msg.Header.Set(HEADER_MBO, p.from)
+	msg.SetDate(time.Now()) // The FBB protocol does not carry the message date.
+	msg.SetFrom(p.from)
+	msg.AddTo(p.to)
+	if p.at != "" {
+		msg.Header.Set(HEADER_AT, p.at)
+	}
+	msg.SetSubject(p.title)
+
+	body, err := BodyFromBytes(text, DefaultCharset)
+	if err != nil {
+		return nil, err
+	}
+	body = strings.Replace(body, "\r\n", "\n", -1)
+	body = strings.Replace(body, "\r", "\n", -1)
+
+	return msg, msg.SetBody(body)
+}
+
+func (p forwardProtocol) proposalLine(prop *Proposal) string {
+	if prop.code == BasicProposal || prop.code == AsciiProposal {
+		return fmt.Sprintf("F%c %s %s %s %s %s %d",
+			prop.code,    // Proposal code
+			prop.msgType, // Message type (P or B)
+			prop.from,    // Sender
+			prop.at,      // BBS of recipient
+			prop.to,      // Recipient
+			prop.mid,     // BID or MID
+			prop.size)    // Size of message
+	}
+
+	return fmt.Sprintf("F%c %s %s %d %d %d",
+		prop.code,           // Proposal code
+		prop.msgType,        // Message type (1 or 2 alphanumeric)
+		prop.mid,            // Max 12 characters
+		prop.size,           // Uncompressed size of message
+		prop.compressedSize, // Compressed size of message
+		0)                   // ?
+}
+
+// writeBasic transmits a message using the basic ascii protocol.
+//
+// The title is sent on the first line, followed by the text and a Ctrl-Z on the last line.
+func (s *Session) writeBasic(w io.Writer, p *Proposal) error {
+	s.log.Printf("Transmitting [%s]", p.title)
+
+	title, _ := toCharset(DefaultCharset, p.title)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\r", title)
+	buf.Write(p.compressedData)
+	if len(p.compressedData) > 0 && p.compressedData[len(p.compressedData)-1] != '\r' {
+		buf.WriteByte('\r')
+	}
+	buf.Write([]byte{_CHRSUB, '\r'})
+
+	tw := s.tx.writer(w)
+	for writeSize := s.optimalWriteSize(w); buf.Len() > 0; {
+		if _, err := tw.Write(buf.Next(writeSize)); err != nil {
+			return err
+		}
+	}
+
+	// Flush connection buffers.
+	if f, ok := w.(transport.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// readBasic receives a message using the basic ascii protocol.
+func (s *Session) readBasic(p *Proposal) error {
+	if err := s.skipNewlines(); err != nil {
+		return err
+	}
+
+	title, err := s.rd.ReadString('\r')
+	if err != nil {
+		return err
+	}
+	s.rxStarted = time.Now()
+	p.title, _ = new(WordDecoder).DecodeHeader(strings.TrimSpace(title))
+
+	s.log.Printf("Receiving [%s]", p.title)
+
+	text, err := s.rd.ReadBytes(_CHRSUB)
+	if err != nil {
+		return err
+	}
+
+	p.compressedData = text[:len(text)-1]
+	p.compressedSize = len(p.compressedData)
+	return nil
+}
+
+// skipNewlines discards any CR or LF bytes waiting in the read buffer.
+//
+// Some FBB implementations terminate the Ctrl-Z line with CR, some does not.
+func (s *Session) skipNewlines() error {
+	for {
+		p, err := s.rd.Peek(1)
+		if err != nil {
+			return err
+		} else if p[0] != '\r' && p[0] != '\n' {
+			return nil
+		}
+		s.rd.ReadByte()
+	}
+}
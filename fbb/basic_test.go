@@ -0,0 +1,238 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/lzhuf"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	tests := map[sid]forwardProtocol{
+		"B2FWIHJM$": protoB2F,
+		"AB1FHMRX$": protoFBBComp1,
+		"BFHM$":     protoFBBComp0,
+		"FHM$":      protoFBBBasic,
+	}
+	for remote, expect := range tests {
+		if got, err := negotiateProtocol(remote); err != nil || got != expect {
+			t.Errorf("%s: Expected %d, got %d (%v)", remote, expect, got, err)
+		}
+	}
+
+	// A SID with B and no F should be treated as having neither
+	for _, remote := range []sid{"BHM$", "HM$"} {
+		if _, err := negotiateProtocol(remote); err != ErrNoFB2 {
+			t.Errorf("%s: Expected ErrNoFB2, got %v", remote, err)
+		}
+	}
+}
+
+func TestFBBProposalRoundtrip(t *testing.T) {
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("F6FBB")
+	msg.SetSubject("Hello FBB")
+	msg.SetBody("Line one\nLine two\n")
+
+	for _, code := range []PropCode{AsciiProposal, BasicProposal} {
+		for _, v0 := range []bool{false, true} {
+			prop, err := msg.fbbProposal(code, v0)
+			if err != nil {
+				t.Fatalf("%c: %s", code, err)
+			}
+			prop.title, prop.at = msg.Subject(), "F6FBB"
+
+			got, err := prop.Message()
+			if err != nil {
+				t.Fatalf("%c: %s", code, err)
+			}
+			if got.Subject() != msg.Subject() || got.MID() != msg.MID() || got.From() != msg.From() {
+				t.Errorf("%c: Unexpected message %s", code, got)
+			}
+			if body, _ := got.Body(); body != "Line one\r\nLine two\r\n" {
+				t.Errorf("%c: Unexpected body %q", code, body)
+			}
+			if got.Header.Get(HEADER_AT) != "F6FBB" {
+				t.Errorf("%c: Missing @BBS field", code)
+			}
+		}
+	}
+
+	msg.AddCc("LA1B")
+	if _, err := msg.fbbProposal(AsciiProposal, false); err == nil {
+		t.Errorf("Expected error for message with multiple recipients")
+	}
+}
+
+func TestSessionFBBBasic(t *testing.T) {
+	client, srv := net.Pipe()
+	srv.SetDeadline(time.Now().Add(10 * time.Second))
+
+	out := NewMessage(Private, "LA5NTA")
+	out.AddTo("F6ABJ")
+	out.SetSubject("Outbound")
+	out.SetBody("Hi there")
+	mbox := newMemMBox(out)
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "F6FBB", "JO39EQ", mbox)
+		s.SetLogger(discardLogger)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[FBB-5.11-FHM$]\r")
+	fmt.Fprint(srv, "Welcome >\r")
+
+	rd := bufio.NewReader(srv)
+	expectLines(t, rd,
+		"[wl2kgo-0.1a-FHM$]\r",
+		"; F6FBB DE LA5NTA (JO39EQ)\r",
+		fmt.Sprintf("FB P LA5NTA F6FBB F6ABJ %s 9\r", out.MID()),
+		"F>\r",
+	)
+	fmt.Fprint(srv, "FS +\r")
+	expectLines(t, rd, "Outbound\r", "Hi there\r", "\x1a\r")
+
+	// Our turn
+	fmt.Fprint(srv, "FB P F6FBB FC1GHV LA5NTA 24657_F6FBB 13\r")
+	fmt.Fprint(srv, "F>\r")
+	expectLines(t, rd, "FS +\r")
+	fmt.Fprint(srv, "Inbound title\rHello world\r\x1a\r")
+
+	expectLines(t, rd, "FF\r")
+	fmt.Fprint(srv, "FQ\r")
+	srv.Close()
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+
+	if _, ok := mbox.sent[out.MID()]; !ok {
+		t.Errorf("Outbound message not marked as sent")
+	}
+	msg, ok := mbox.in["24657_F6FBB"]
+	switch {
+	case !ok:
+		t.Fatalf("Inbound message not received")
+	case msg.Subject() != "Inbound title":
+		t.Errorf("Unexpected subject '%s'", msg.Subject())
+	case msg.From().Addr != "F6FBB" || msg.To()[0].Addr != "LA5NTA":
+		t.Errorf("Unexpected addresses %s -> %s", msg.From(), msg.To())
+	}
+	if body, _ := msg.Body(); body != "Hello world\r\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestSessionFBBCompressed(t *testing.T) {
+	client, srv := net.Pipe()
+	srv.SetDeadline(time.Now().Add(10 * time.Second))
+
+	mbox := newMemMBox()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "F6FBB", "JO39EQ", mbox)
+		s.SetLogger(discardLogger)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[FBB-5.15-B1FHM$]\r")
+	fmt.Fprint(srv, "Welcome >\r")
+
+	rd := bufio.NewReader(srv)
+	expectLines(t, rd,
+		"[wl2kgo-0.1a-B1FHM$]\r",
+		"; F6FBB DE LA5NTA (JO39EQ)\r",
+		"FF\r",
+	)
+
+	text := "Hello compressed world\r"
+	var compressed bytes.Buffer
+	z := lzhuf.NewWriter(&compressed, true)
+	io.WriteString(z, text)
+	z.Close()
+
+	line := fmt.Sprintf("FA P F6FBB FC1GHV LA5NTA 24658_F6FBB %d", len(text))
+	fmt.Fprintf(srv, "%s\r", line)
+	fmt.Fprintf(srv, "F> %02X\r", proposalChecksum(line))
+	expectLines(t, rd, "FS +\r")
+	writeCompressedBlock(srv, "Compressed title", compressed.Bytes())
+
+	expectLines(t, rd, "FF\r")
+	fmt.Fprint(srv, "FQ\r")
+	srv.Close()
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+
+	msg, ok := mbox.in["24658_F6FBB"]
+	if !ok {
+		t.Fatalf("Inbound message not received")
+	} else if msg.Subject() != "Compressed title" {
+		t.Errorf("Unexpected subject '%s'", msg.Subject())
+	}
+	if body, _ := msg.Body(); body != "Hello compressed world\r\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func expectLines(t *testing.T, rd *bufio.Reader, lines ...string) {
+	t.Helper()
+	for i, expected := range lines {
+		line, _ := rd.ReadString('\r')
+		if line != expected {
+			line, expected = strings.TrimSpace(line), strings.TrimSpace(expected)
+			t.Fatalf("Unexpected line [%d]: Got '%s', expected '%s'.", i, line, expected)
+		}
+	}
+}
+
+func proposalChecksum(lines ...string) int64 {
+	var sum int64
+	for _, line := range lines {
+		for _, c := range line {
+			sum += int64(c)
+		}
+		sum += int64('\r')
+	}
+	return (-sum) & 0xff
+}
+
+// writeCompressedBlock writes the header, data and checksum of a compressed message transfer.
+func writeCompressedBlock(w io.Writer, title string, data []byte) {
+	var buf bytes.Buffer
+	buf.Write([]byte{_CHRSOH, byte(len(title) + 3)})
+	buf.WriteString(title)
+	buf.Write([]byte{_CHRNUL, '0', _CHRNUL})
+
+	var sum int
+	for len(data) > 0 {
+		n := len(data)
+		if n > 250 {
+			n = 250
+		}
+		buf.Write([]byte{_CHRSTX, byte(n)})
+		buf.Write(data[:n])
+		for _, c := range data[:n] {
+			sum += int(c)
+		}
+		data = data[n:]
+	}
+	buf.Write([]byte{_CHREOT, byte(-sum & 0xff)})
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// This file implements compressed batch forwarding (SID flag X).
+//
+// When both parties advertise the X flag, consecutive small outbound messages are
+// combined and compressed as a single B2F proposal (message type XB), saving the
+// per-message proposal, header and checksum overhead. The batch data is the raw
+// messages, each prefixed by a line holding its length in bytes.
+
+// batchMsgType is the B2F proposal message type of compressed batches.
+const batchMsgType = "XB"
+
+// SetBatchLimit enables compressed batch forwarding (SID flag X) with remotes supporting it.
+//
+// Outbound messages smaller than n bytes (uncompressed) are combined in batches of up to n bytes,
+// and proposed as a single message. Inbound batches are accepted when enabled. A value <= 0
+// disables batch forwarding (default).
+func (s *Session) SetBatchLimit(n int) { s.batchLimit = n }
+
+// batchSID returns the X flag if batch forwarding is enabled.
+func (s *Session) batchSID() string {
+	if s.batchLimit <= 0 {
+		return ""
+	}
+	return sCompBatchF
+}
+
+// batchEnabled returns true if batch forwarding was negotiated with the remote.
+func (s *Session) batchEnabled() bool {
+	return s.batchLimit > 0 && s.proto == protoB2F && s.remoteSID.Has(sCompBatchF)
+}
+
+// isBatch returns true if this is a proposal of a compressed batch of messages.
+func (p *Proposal) isBatch() bool { return p.msgType == batchMsgType }
+
+// batchOutbound combines consecutive proposals of messages smaller than the batch limit into
+// compressed batches, as long as the combined size is within the limit.
+func (s *Session) batchOutbound(msgs []*Message, props []*Proposal) []*Proposal {
+	if !s.batchEnabled() {
+		return props
+	}
+
+	var (
+		out     = make([]*Proposal, 0, len(props))
+		batch   []*Message
+		members []*Proposal
+		size    int
+	)
+	flush := func() {
+		switch len(batch) {
+		case 0:
+		case 1:
+			out = append(out, members[0])
+		default:
+			prop, err := s.batchProposal(batch)
+			if err != nil {
+				s.log.Printf("Unable to prepare batch proposal: %s. Proposing messages separately.", err)
+				out = append(out, members...)
+				break
+			}
+			out = append(out, prop)
+		}
+		batch, members, size = nil, nil, 0
+	}
+
+	for i, prop := range props {
+		if prop.size >= s.batchLimit {
+			flush()
+			out = append(out, prop)
+			continue
+		}
+		if size+prop.size > s.batchLimit {
+			flush()
+		}
+		batch, members = append(batch, msgs[i]), append(members, prop)
+		size += prop.size
+	}
+	flush()
+	return out
+}
+
+// batchProposal returns the smallest proposal of the given messages as a compressed batch.
+func (s *Session) batchProposal(msgs []*Message) (*Proposal, error) {
+	var buf bytes.Buffer
+	mids := make([]string, len(msgs))
+	for i, m := range msgs {
+		data, err := m.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%d\r\n", len(data))
+		buf.Write(data)
+		mids[i] = m.MID()
+	}
+
+	var (
+		mid   = batchMID(mids)
+		title = fmt.Sprintf("Batch of %d messages", len(msgs))
+		prop  *Proposal
+	)
+	for _, code := range s.remoteCodecs() {
+		p := NewProposal(mid, title, code, buf.Bytes())
+		if prop == nil || p.compressedSize < prop.compressedSize {
+			prop = p
+		}
+	}
+	prop.msgType = batchMsgType
+
+	if s.batches == nil {
+		s.batches = make(map[string][]string)
+	}
+	s.batches[mid] = mids
+	s.log.Printf("Batching %v as %s", mids, mid)
+	return prop, nil
+}
+
+// batchMID returns the MID of a batch of the given messages.
+//
+// The MID is derived from the MIDs of the messages, so that an interrupted transfer of the
+// same batch can be resumed (see PartialStore).
+func batchMID(mids []string) string {
+	return derivedMID(strings.Join(mids, ","))
+}
+
+// batchMembers returns the MIDs of the messages in the outbound batch identified by MID,
+// or MID itself if it's not a batch.
+func (s *Session) batchMembers(MID string) []string {
+	if mids, ok := s.batches[MID]; ok {
+		return mids
+	}
+	return []string{MID}
+}
+
+// batchMessages returns the messages of a received batch, spooling attachments larger than
+// spoolThreshold bytes to spoolDir (see Proposal.message).
+//
+// The messages are parsed as the batch is decompressed, so the raw batch is never held in memory.
+func (p *Proposal) batchMessages(spoolDir string, spoolThreshold int) ([]*Message, error) {
+	r, err := p.reader()
+	if err != nil {
+		return nil, checksumError(p.MID(), err)
+	}
+
+	msgs, err := readBatch(bufio.NewReader(r), spoolDir, spoolThreshold)
+
+	// Consume any trailing data, so that the checksum is verified
+	if _, drainErr := io.Copy(ioutil.Discard, r); err == nil {
+		err = drainErr
+	}
+	if closeErr := r.Close(); closeErr != nil {
+		err = checksumError(p.MID(), closeErr)
+	}
+	if err != nil {
+		for _, m := range msgs {
+			m.removeSpooled()
+		}
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// readBatch reads the length prefixed messages of a batch from r.
+//
+// The messages read are returned, also on error.
+func readBatch(r *bufio.Reader, spoolDir string, spoolThreshold int) ([]*Message, error) {
+	var msgs []*Message
+	for {
+		line, err := r.ReadString('\n')
+		switch {
+		case err == io.EOF && line == "":
+			return msgs, nil
+		case err == io.EOF:
+			return msgs, errors.New("Malformed batch: missing length line")
+		case err != nil:
+			return msgs, err
+		}
+
+		n, err := strconv.Atoi(strings.TrimSuffix(line, "\r\n"))
+		if err != nil || n < 0 {
+			return msgs, fmt.Errorf("Malformed batch: invalid length %q", strings.TrimSpace(line))
+		}
+
+		lr := &io.LimitedReader{R: r, N: int64(n)}
+		m := new(Message)
+		err = m.readFrom(lr, spoolDir, spoolThreshold)
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, lr) // Trailing data of the message
+		}
+		if err == nil && lr.N > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			m.removeSpooled()
+			return msgs, fmt.Errorf("Malformed batch: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// inboundMessages returns the message(s) of a received proposal.
+//
+// Messages of a batch already received (according to the BIDStore or MBoxHandler) are left out.
+func (s *Session) inboundMessages(p *Proposal) ([]*Message, error) {
+	if !p.isBatch() {
+		msg, err := p.message(s.spoolDir, s.spoolThreshold)
+		if err != nil {
+			return nil, err
+		}
+		return []*Message{msg}, nil
+	}
+
+	msgs, err := p.batchMessages(s.spoolDir, s.spoolThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	var fresh []*Message
+	for _, m := range msgs {
+		member := Proposal{code: p.code, msgType: "EM", mid: m.MID(), title: m.Subject(), size: m.BodySize()}
+		switch {
+		case s.bids != nil && s.bids.HasBID(m.BID()):
+			s.log.Printf("Ignoring %s of batch %s (BID seen before)", m.MID(), p.MID())
+		case s.h.GetInboundAnswer(member) == Reject:
+			s.log.Printf("Ignoring %s of batch %s (already received)", m.MID(), p.MID())
+		default:
+			fresh = append(fresh, m)
+			continue
+		}
+		m.removeSpooled()
+	}
+	return fresh, nil
+}
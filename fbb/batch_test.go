@@ -0,0 +1,178 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// inboundProposals returns the proposals received by the session, as recorded by r.
+func (r eventRecorder) inboundProposals() []*Proposal {
+	var props []*Proposal
+	for _, e := range r {
+		if e.Type == EventProposal && !e.Outbound {
+			props = append(props, e.Proposal)
+		}
+	}
+	return props
+}
+
+func TestBatchForwarding(t *testing.T) {
+	tests := []struct {
+		masterLimit, clientLimit int
+		expectProposals          int
+	}{
+		{2048, 2048, 2}, // The small messages in one batch, the large one separately
+		{2048, 0, 4},    // Not negotiated
+		{0, 2048, 4},    // Not negotiated
+	}
+
+	for i, test := range tests {
+		msgs := []*Message{
+			testMessage("N0CALL", "LA5NTA", 100),
+			testMessage("N0CALL", "LA5NTA", 150),
+			testMessage("N0CALL", "LA5NTA", 200),
+			testMessage("N0CALL", "LA5NTA", 2500),
+		}
+		for j, m := range msgs {
+			m.Header.Set(HEADER_MID, fmt.Sprintf("BATCH%d%d", i, j)) // Unique, regardless of clock resolution
+		}
+
+		var events eventRecorder
+		masterBox, clientBox := newMemMBox(), newMemMBox(msgs...)
+		masterConn, clientConn := net.Pipe()
+		master := NewSession("LA5NTA", "N0CALL", "JO39EQ", masterBox)
+		master.SetLogger(discardLogger)
+		master.SetBatchLimit(test.masterLimit)
+		master.SetEventObserver(&events)
+		client := NewSession("N0CALL", "LA5NTA", "JO39EQ", clientBox)
+		client.SetLogger(discardLogger)
+		client.SetBatchLimit(test.clientLimit)
+
+		if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+			t.Fatalf("%d: Exchange failed: %v, %v", i, masterErr, clientErr)
+		}
+
+		if props := events.inboundProposals(); len(props) != test.expectProposals {
+			t.Errorf("%d: Expected %d proposals, got %d", i, test.expectProposals, len(props))
+		}
+
+		var expect []string
+		for _, m := range msgs {
+			expect = append(expect, m.MID())
+			if _, ok := masterBox.in[m.MID()]; !ok {
+				t.Errorf("%d: %s not received", i, m.MID())
+			}
+		}
+		if len(clientBox.out) != 0 {
+			t.Errorf("%d: Expected all messages to be marked as sent", i)
+		}
+
+		sent, received := client.stats().Sent, master.stats().Received
+		if !equalMIDs(sent, expect) || !equalMIDs(received, expect) {
+			t.Errorf("%d: Unexpected traffic stats: sent %v, received %v", i, sent, received)
+		}
+	}
+}
+
+func TestBatchMembersBIDAndSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fbb-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	msgs := []*Message{
+		testMessage("N0CALL", "LA5NTA", 100),
+		testMessage("N0CALL", "LA5NTA", 150),
+		testMessage("N0CALL", "LA5NTA", 200),
+	}
+	for i, m := range msgs {
+		m.Header.Set(HEADER_MID, fmt.Sprintf("BATCHBID%d", i))
+	}
+	attachment := bytes.Repeat([]byte("attachment "), 50)
+	msgs[0].AddFile(NewFile("attachment.txt", attachment))
+
+	var events eventRecorder
+	masterBox := &spoolMBox{memMBox: newMemMBox()}
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", masterBox)
+	master.SetLogger(discardLogger)
+	master.SetBatchLimit(4096)
+	master.SetBIDStore(memBIDStore{msgs[2].MID(): true})
+	master.SetSpool(dir, 100)
+	master.SetEventObserver(&events)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(msgs...))
+	client.SetLogger(discardLogger)
+	client.SetBatchLimit(4096)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	if props := events.inboundProposals(); len(props) != 1 || !props[0].isBatch() {
+		t.Fatalf("Expected a single batch proposal, got %d proposals", len(props))
+	}
+	for _, m := range msgs[:2] {
+		if _, ok := masterBox.in[m.MID()]; !ok {
+			t.Errorf("%s not received", m.MID())
+		}
+	}
+	if _, ok := masterBox.in[msgs[2].MID()]; ok {
+		t.Errorf("Batch member with BID seen before was delivered")
+	}
+
+	switch {
+	case len(masterBox.paths) != 1:
+		t.Fatalf("Expected 1 attachment, got %d", len(masterBox.paths))
+	case filepath.Dir(masterBox.paths[0]) != dir || !bytes.Equal(masterBox.data[0], attachment):
+		t.Errorf("Expected attachment spooled to %s, got %q", dir, masterBox.paths[0])
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected spooled files to be removed, got %d", len(files))
+	}
+}
+
+func TestBatchMessages(t *testing.T) {
+	msgs := []*Message{testMessage("N0CALL", "LA5NTA", 10), testMessage("N0CALL", "LA5NTA", 20)}
+	msgs[0].Header.Set(HEADER_MID, "BATCH1")
+	msgs[1].Header.Set(HEADER_MID, "BATCH2")
+
+	s := NewSession("N0CALL", "LA5NTA", "JO39EQ", nil)
+	s.SetLogger(discardLogger)
+	prop, err := s.batchProposal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prop.isBatch() || !equalMIDs(s.batchMembers(prop.MID()), []string{msgs[0].MID(), msgs[1].MID()}) {
+		t.Errorf("Unexpected batch proposal %+v", prop)
+	}
+	if other, _ := s.batchProposal(msgs); other.MID() != prop.MID() {
+		t.Errorf("Expected the same MID for a batch of the same messages")
+	}
+
+	got, err := prop.batchMessages("", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("Expected %d messages, got %d", len(msgs), len(got))
+	}
+	for i, m := range got {
+		if m.MID() != msgs[i].MID() || m.BodySize() != msgs[i].BodySize() {
+			t.Errorf("Unexpected message %d: %s", i, m)
+		}
+	}
+
+	if _, err := NewProposal("BATCH", "", Wl2kProposal, []byte("12\r\nshort")).batchMessages("", -1); err == nil {
+		t.Errorf("Expected error for malformed batch")
+	}
+}
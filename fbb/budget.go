@@ -0,0 +1,92 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import "time"
+
+// Budget limits the traffic of a session, e.g. to keep sessions on a shared frequency time-boxed.
+//
+// When a budget is spent, no more messages are proposed and the remaining inbound proposals are
+// deferred. The messages left are reported in TrafficStats. Zero values means unlimited.
+type Budget struct {
+	// The time from the start of the exchange after which no more messages are transferred.
+	Duration time.Duration
+
+	// The max number of bytes (as transferred, i.e. compressed) to send and receive.
+	Bytes int
+
+	// The max number of messages to send and receive.
+	Messages int
+}
+
+// SetBudget sets the traffic budget of the session.
+func (s *Session) SetBudget(b Budget) { s.budget = b }
+
+// budgetUsage holds the traffic accounted against the session's budget.
+type budgetUsage struct {
+	started  time.Time
+	bytes    int
+	messages int
+}
+
+// withinBudget returns true if transferring the given number of bytes and messages (in addition to
+// what's already transferred) does not exceed the session budget.
+func (s *Session) withinBudget(bytes, messages int) bool {
+	b, u := s.budget, s.used
+	switch {
+	case b.Duration > 0 && !u.started.IsZero() && time.Since(u.started) >= b.Duration:
+		return false
+	case b.Bytes > 0 && u.bytes+bytes > b.Bytes:
+		return false
+	case b.Messages > 0 && u.messages+messages > b.Messages:
+		return false
+	default:
+		return true
+	}
+}
+
+// spend accounts the transfer of the given proposal against the session budget.
+func (s *Session) spend(p *Proposal) {
+	s.used.bytes += p.transferSize()
+	s.used.messages++
+}
+
+// budgetOutbound returns the leading proposals that can be sent within the session budget.
+//
+// The MIDs of the proposals left are added to TrafficStats.
+func (s *Session) budgetOutbound(props []*Proposal) []*Proposal {
+	var bytes int
+	for i, prop := range props {
+		bytes += prop.transferSize()
+		if s.withinBudget(bytes, i+1) {
+			continue
+		}
+
+		for _, left := range props[i:] {
+			for _, mid := range s.batchMembers(left.MID()) {
+				s.trafficStats.OutboundLeft = appendUnique(s.trafficStats.OutboundLeft, mid)
+			}
+		}
+		return props[:i]
+	}
+	return props
+}
+
+// transferSize returns the number of bytes to transfer (or transferred) for the proposal.
+func (p *Proposal) transferSize() int {
+	if p.compressedSize < 0 {
+		return p.size // Unknown until received (FBB compressed protocols)
+	}
+	return p.compressedSize - p.offset
+}
+
+func appendUnique(slice []string, str string) []string {
+	for _, s := range slice {
+		if s == str {
+			return slice
+		}
+	}
+	return append(slice, str)
+}
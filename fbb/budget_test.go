@@ -0,0 +1,95 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	small, large := testMessage("LA5NTA", "LA1B", 100), testMessage("LA5NTA", "LA1B", 5000)
+	in1, in2 := testMessage("LA1B", "LA5NTA", 200), testMessage("LA1B", "LA5NTA", 300)
+
+	tests := []struct {
+		budget                    Budget
+		sent, received            []string
+		outboundLeft, inboundLeft []string
+	}{
+		{
+			Budget{},
+			[]string{small.MID(), large.MID()}, []string{in1.MID(), in2.MID()}, nil, nil,
+		},
+		{
+			Budget{Messages: 1},
+			[]string{small.MID()}, nil, []string{large.MID()}, []string{in1.MID(), in2.MID()},
+		},
+		{
+			Budget{Messages: 3},
+			[]string{small.MID(), large.MID()}, []string{in1.MID()}, nil, []string{in2.MID()},
+		},
+		{
+			Budget{Bytes: compressedSize(small) + compressedSize(in1)},
+			[]string{small.MID()}, []string{in1.MID()}, []string{large.MID()}, []string{in2.MID()},
+		},
+		{
+			Budget{Duration: time.Nanosecond},
+			nil, nil, []string{small.MID(), large.MID()}, []string{in1.MID(), in2.MID()},
+		},
+	}
+
+	for i, test := range tests {
+		clientConn, masterConn := net.Pipe()
+
+		master := NewSession("LA1B", "LA5NTA", "JO39EQ", newMemMBox(in1, in2))
+		master.SetLogger(discardLogger)
+		master.IsMaster(true)
+
+		client := NewSession("LA5NTA", "LA1B", "JO39EQ", newMemMBox(small, large))
+		client.SetLogger(discardLogger)
+		client.SetBudget(test.budget)
+
+		errs := make(chan error, 1)
+		go func() { _, err := master.Exchange(masterConn); errs <- err }()
+		stats, err := client.Exchange(clientConn)
+		if err != nil {
+			t.Fatalf("%d: Client exchange failed: %s", i, err)
+		} else if err := <-errs; err != nil {
+			t.Fatalf("%d: Master exchange failed: %s", i, err)
+		}
+
+		if !equalMIDs(stats.Sent, test.sent) {
+			t.Errorf("%d: Expected sent %v, got %v", i, test.sent, stats.Sent)
+		}
+		if !equalMIDs(stats.Received, test.received) {
+			t.Errorf("%d: Expected received %v, got %v", i, test.received, stats.Received)
+		}
+		if !equalMIDs(stats.OutboundLeft, test.outboundLeft) {
+			t.Errorf("%d: Expected outbound left %v, got %v", i, test.outboundLeft, stats.OutboundLeft)
+		}
+		if !equalMIDs(stats.InboundLeft, test.inboundLeft) {
+			t.Errorf("%d: Expected inbound left %v, got %v", i, test.inboundLeft, stats.InboundLeft)
+		}
+	}
+}
+
+func compressedSize(msg *Message) int {
+	prop, _ := msg.Proposal(Wl2kProposal)
+	return prop.compressedSize
+}
+
+// equalMIDs returns true if the two slices of MIDs holds the same MIDs, regardless of order.
+func equalMIDs(a, b []string) bool {
+	set := func(mids []string) map[string]bool {
+		m := make(map[string]bool)
+		for _, mid := range mids {
+			m[mid] = true
+		}
+		return m
+	}
+	return len(a) == len(b) && reflect.DeepEqual(set(a), set(b))
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"errors"
+	"strings"
+)
+
+// This file implements bulletins and hierarchical (@BBS) addressing as used by packet BBS networks.
+//
+// A bulletin is addressed to a category (e.g. ALL, WX or SALE) at a distribution area
+// (e.g. WW, EU or #OSL.NOR.EU), and is flooded through the network from neighbour to neighbour.
+// Every bulletin carries a network wide unique bulletin ID (BID), which is used by every node
+// to receive a bulletin only once. In B2F, the MID of the message is used as BID.
+
+// A BIDStore holds the history of bulletin IDs (BID) seen by the local node, so that bulletins
+// flooding the network are received only once.
+//
+// Inbound proposals identified by a BID (or MID) found in the store are rejected by the Session
+// without consulting the MBoxHandler, and the BID of every received bulletin is added to the store.
+//
+// If the Session's MBoxHandler implements this interface, it will be used by default.
+type BIDStore interface {
+	// HasBID returns true if the bulletin identified by BID has been seen before.
+	HasBID(BID string) bool
+
+	// AddBID should record the bulletin identified by BID as seen.
+	AddBID(BID string) error
+}
+
+// SetBIDStore sets the BIDStore used to reject bulletins seen before.
+//
+// A nil value disables BID dedup (leaving it to the MBoxHandler).
+func (s *Session) SetBIDStore(store BIDStore) { s.bids = store }
+
+// A HierarchicalAddress is a packet BBS address, such as ALL@WW or LA5NTA@LA1BBS.#OSL.NOR.EU.
+type HierarchicalAddress struct {
+	// The call sign of the recipient (private messages) or the bulletin category (e.g. ALL).
+	To string
+
+	// The hierarchical location (@BBS) of the recipient, most specific first
+	// (e.g. LA1BBS, #OSL, NOR, EU). For bulletins, this is the distribution area (e.g. WW).
+	At []string
+}
+
+// ParseHierarchicalAddress parses a hierarchical address on the form TO@LOCATION.
+//
+// The address is upper-cased. The location part is optional.
+func ParseHierarchicalAddress(str string) (HierarchicalAddress, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(str)), "@")
+	switch {
+	case len(parts) > 2:
+		return HierarchicalAddress{}, errors.New("Malformed hierarchical address: " + str)
+	case parts[0] == "" || strings.ContainsAny(parts[0], " :."):
+		return HierarchicalAddress{}, errors.New("Malformed hierarchical address: " + str)
+	case len(parts) == 1:
+		return HierarchicalAddress{To: parts[0]}, nil
+	}
+
+	addr := HierarchicalAddress{To: parts[0], At: strings.Split(parts[1], ".")}
+	for _, elem := range addr.At {
+		if elem == "" || elem == "#" {
+			return HierarchicalAddress{}, errors.New("Malformed hierarchical address: " + str)
+		}
+	}
+	return addr, nil
+}
+
+// Location returns the hierarchical location of the address (e.g. LA1BBS.#OSL.NOR.EU).
+func (a HierarchicalAddress) Location() string { return strings.Join(a.At, ".") }
+
+func (a HierarchicalAddress) String() string {
+	if len(a.At) == 0 {
+		return a.To
+	}
+	return a.To + "@" + a.Location()
+}
+
+// NewBulletin initializes and returns a new bulletin with Type, Mbo, From, Date, To and At set.
+//
+// The bulletin is addressed to a category at a distribution area, e.g. ALL@WW.
+func NewBulletin(mycall, addr string) (*Message, error) {
+	haddr, err := ParseHierarchicalAddress(addr)
+	if err != nil {
+		return nil, err
+	} else if len(haddr.At) == 0 {
+		return nil, errors.New("Bulletin address must include a distribution area (e.g. ALL@WW)")
+	}
+
+	msg := NewMessage(Bulletin, mycall)
+	msg.AddTo(haddr.To)
+	msg.SetAt(haddr.Location())
+	return msg, nil
+}
+
+// IsBulletin returns true if the message type is Bulletin.
+func (m *Message) IsBulletin() bool { return m.Type() == Bulletin }
+
+// BID returns the bulletin ID of the message. The MID is used as BID.
+func (m *Message) BID() string { return m.MID() }
+
+// SetAt sets the hierarchical location (@BBS) of the recipient, or the distribution area of a bulletin.
+func (m *Message) SetAt(location string) { m.Header.Set(HEADER_AT, strings.ToUpper(location)) }
+
+// At returns the hierarchical location (@BBS) of the recipient, or the distribution area of a bulletin.
+func (m *Message) At() string { return m.Header.Get(HEADER_AT) }
+
+// HierarchicalAddress returns the hierarchical address of the message's first recipient and the At header field.
+func (m *Message) HierarchicalAddress() HierarchicalAddress {
+	var addr HierarchicalAddress
+	if to := m.To(); len(to) > 0 {
+		addr.To = to[0].Addr
+	}
+	if at := m.At(); at != "" {
+		addr.At = strings.Split(at, ".")
+	}
+	return addr
+}
+
+// IsBulletin returns true if the proposal is a FBB bulletin proposal (message type B).
+//
+// B2F proposals does not distinguish bulletins from other messages.
+func (p *Proposal) IsBulletin() bool { return p.msgType == "B" }
+
+// ForwardRule defines which bulletins should be forwarded to a neighbour node.
+type ForwardRule struct {
+	// The call sign of the neighbour.
+	Neighbour string
+
+	// The distribution areas served by the neighbour (e.g. WW, EU, NOR and #OSL).
+	//
+	// A bulletin is forwarded to the neighbour if its distribution area matches one of these.
+	Areas []string
+
+	// The bulletin categories (e.g. ALL, WX) forwarded to the neighbour. Empty means all categories.
+	Categories []string
+}
+
+// Match returns true if the bulletin should be forwarded to the rule's neighbour.
+func (r ForwardRule) Match(msg *Message) bool {
+	if !msg.IsBulletin() {
+		return false
+	}
+
+	addr := msg.HierarchicalAddress()
+	if len(r.Categories) > 0 && !containsFold(r.Categories, addr.To) {
+		return false
+	}
+
+	// A distribution of WW only matches neighbours serving WW.
+	for _, elem := range addr.At {
+		if containsFold(r.Areas, elem) || containsFold(r.Areas, strings.TrimPrefix(elem, "#")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForwardRules is a set of forwarding rules, one (or more) per neighbour.
+type ForwardRules []ForwardRule
+
+// Neighbours returns the call signs of the neighbours the bulletin should be forwarded to.
+//
+// The neighbour the bulletin was received from (if any) and the bulletin's origin (Mbo) are excluded.
+func (rules ForwardRules) Neighbours(msg *Message, receivedFrom string) []string {
+	var calls []string
+	for _, r := range rules {
+		call := strings.ToUpper(r.Neighbour)
+		switch {
+		case call == strings.ToUpper(receivedFrom), call == strings.ToUpper(msg.Mbo()):
+		case containsFold(calls, call):
+		case r.Match(msg):
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// SetForwardRules sets the rules deciding which outbound bulletins are proposed to the remote.
+//
+// Bulletins returned by the MBoxHandler are only proposed if the rules forward them to the
+// remote's call sign (see ForwardRules.Forward). Other messages are not affected.
+//
+// A nil value (the default) proposes all bulletins.
+func (s *Session) SetForwardRules(rules ForwardRules) { s.forwardRules = rules }
+
+// forward returns false if msg is a bulletin that should not be proposed to the remote (see SetForwardRules).
+func (s *Session) forward(msg *Message) bool {
+	if s.forwardRules == nil || !msg.IsBulletin() {
+		return true
+	}
+	return s.forwardRules.Forward(msg, "", s.targetcall)
+}
+
+// Forward returns true if the bulletin should be forwarded to the given neighbour.
+//
+// See Neighbours.
+func (rules ForwardRules) Forward(msg *Message, receivedFrom, neighbour string) bool {
+	return containsFold(rules.Neighbours(msg, receivedFrom), neighbour)
+}
+
+func containsFold(slice []string, str string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(s, str) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHierarchicalAddress(t *testing.T) {
+	tests := map[string]HierarchicalAddress{
+		"ALL@WW":                    {To: "ALL", At: []string{"WW"}},
+		"la5nta@la1bbs.#osl.nor.eu": {To: "LA5NTA", At: []string{"LA1BBS", "#OSL", "NOR", "EU"}},
+		"LA5NTA":                    {To: "LA5NTA"},
+	}
+	for str, expect := range tests {
+		got, err := ParseHierarchicalAddress(str)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %s", str, err)
+		} else if !reflect.DeepEqual(got, expect) {
+			t.Errorf("%s: Expected %#v, got %#v", str, expect, got)
+		}
+	}
+
+	for _, str := range []string{"", "@WW", "ALL@", "ALL@WW@EU", "ALL@NOR..EU", "SMTP:foo@bar"} {
+		if _, err := ParseHierarchicalAddress(str); err == nil {
+			t.Errorf("%s: Expected error", str)
+		}
+	}
+}
+
+func TestNewBulletin(t *testing.T) {
+	msg, err := NewBulletin("LA5NTA", "wx@#osl.nor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetSubject("Weather")
+	msg.SetBody("Sunny")
+
+	if err := msg.Validate(); err != nil {
+		t.Errorf("Unexpected validation error: %s", err)
+	}
+	if !msg.IsBulletin() || msg.BID() != msg.MID() {
+		t.Errorf("Not a bulletin")
+	}
+	if addr := msg.HierarchicalAddress().String(); addr != "WX@#OSL.NOR" {
+		t.Errorf("Unexpected hierarchical address %s", addr)
+	}
+
+	if _, err := NewBulletin("LA5NTA", "ALL"); err == nil {
+		t.Errorf("Expected error for bulletin without distribution area")
+	}
+}
+
+func TestForwardRules(t *testing.T) {
+	rules := ForwardRules{
+		{Neighbour: "LA1BBS", Areas: []string{"WW", "EU", "NOR"}},
+		{Neighbour: "LA2BBS", Areas: []string{"WW", "EU", "NOR", "OSL"}, Categories: []string{"ALL", "WX"}},
+		{Neighbour: "SM0BBS", Areas: []string{"WW", "EU"}},
+	}
+
+	tests := []struct {
+		addr, mbo, from string
+		expect          []string
+	}{
+		{"ALL@WW", "LA5NTA", "", []string{"LA1BBS", "LA2BBS", "SM0BBS"}},
+		{"ALL@WW", "LA5NTA", "LA1BBS", []string{"LA2BBS", "SM0BBS"}},
+		{"ALL@WW", "SM0BBS", "LA1BBS", []string{"LA2BBS"}},
+		{"SALE@NOR", "LA5NTA", "", []string{"LA1BBS"}},
+		{"WX@#OSL.NOR", "LA5NTA", "", []string{"LA1BBS", "LA2BBS"}},
+		{"WX@#BGO", "LA5NTA", "", nil},
+	}
+	for _, test := range tests {
+		msg, _ := NewBulletin(test.mbo, test.addr)
+		if got := rules.Neighbours(msg, test.from); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s from %s: Expected %v, got %v", test.addr, test.from, test.expect, got)
+		}
+	}
+
+	// Private messages are never matched
+	if got := rules.Neighbours(testMessage("LA5NTA", "LA1B", 10), ""); len(got) > 0 {
+		t.Errorf("Private message matched %v", got)
+	}
+}
+
+func TestSessionForwardRules(t *testing.T) {
+	ww, _ := NewBulletin("LA5NTA", "ALL@WW")
+	ww.SetSubject("Hi all")
+	ww.SetBody("Hi all")
+	nor, _ := NewBulletin("LA5NTA", "SALE@NOR")
+	nor.SetSubject("For sale")
+	nor.SetBody("For sale")
+	private := testMessage("LA5NTA", "N0CALL", 100)
+
+	masterBox, clientBox := newMemMBox(ww, nor, private), newMemMBox()
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", masterBox)
+	master.SetLogger(discardLogger)
+	master.SetForwardRules(ForwardRules{{Neighbour: "N0CALL", Areas: []string{"WW"}}})
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", clientBox)
+	client.SetLogger(discardLogger)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	for _, msg := range []*Message{ww, private} {
+		if _, ok := clientBox.in[msg.MID()]; !ok {
+			t.Errorf("Expected %s to be forwarded", msg.MID())
+		}
+	}
+	if _, ok := clientBox.in[nor.MID()]; ok {
+		t.Errorf("Bulletin to NOR forwarded to neighbour serving WW")
+	}
+	if _, ok := masterBox.out[nor.MID()]; !ok || len(masterBox.out) != 1 {
+		t.Errorf("Expected bulletin not forwarded to stay in the outbox, got %v", masterBox.out)
+	}
+}
+
+type memBIDStore map[string]bool
+
+func (s memBIDStore) HasBID(BID string) bool  { return s[BID] }
+func (s memBIDStore) AddBID(BID string) error { s[BID] = true; return nil }
+
+func TestSessionFBBBulletin(t *testing.T) {
+	client, srv := net.Pipe()
+	srv.SetDeadline(time.Now().Add(10 * time.Second))
+
+	out, _ := NewBulletin("LA5NTA", "ALL@WW")
+	out.SetSubject("Outbound bulletin")
+	out.SetBody("Hi all")
+	mbox := newMemMBox(out)
+	bids := memBIDStore{"1000_F6FBB": true}
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "F6FBB", "JO39EQ", mbox)
+		s.SetLogger(discardLogger)
+		s.SetBIDStore(bids)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[FBB-5.11-FHM$]\r")
+	fmt.Fprint(srv, "Welcome >\r")
+
+	rd := bufio.NewReader(srv)
+	expectLines(t, rd,
+		"[wl2kgo-0.1a-FHM$]\r",
+		"; F6FBB DE LA5NTA (JO39EQ)\r",
+		fmt.Sprintf("FB B LA5NTA WW ALL %s 7\r", out.MID()),
+		"F>\r",
+	)
+	fmt.Fprint(srv, "FS +\r")
+	expectLines(t, rd, "Outbound bulletin\r", "Hi all\r", "\x1a\r")
+
+	// Our turn. The first bulletin has been seen before.
+	fmt.Fprint(srv, "FB B F6FBB WW ALL 1000_F6FBB 13\r")
+	fmt.Fprint(srv, "FB B F6FBB EU WX 1001_F6FBB 13\r")
+	fmt.Fprint(srv, "F>\r")
+	expectLines(t, rd, "FS -+\r")
+	fmt.Fprint(srv, "Inbound title\rHello world\r\x1a\r")
+
+	expectLines(t, rd, "FF\r")
+	fmt.Fprint(srv, "FQ\r")
+	srv.Close()
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+
+	msg, ok := mbox.in["1001_F6FBB"]
+	switch {
+	case !ok:
+		t.Fatalf("Inbound bulletin not received")
+	case !msg.IsBulletin():
+		t.Errorf("Unexpected message type '%s'", msg.Type())
+	case msg.HierarchicalAddress().String() != "WX@EU":
+		t.Errorf("Unexpected address %s", msg.HierarchicalAddress())
+	}
+	if _, ok := mbox.in["1000_F6FBB"]; ok {
+		t.Errorf("Bulletin seen before was received")
+	}
+	if !bids["1001_F6FBB"] {
+		t.Errorf("BID of received bulletin not added to store")
+	}
+}
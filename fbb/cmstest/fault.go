@@ -0,0 +1,60 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package cmstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var errLinkDropped = errors.New("Link dropped (injected failure)")
+
+// faultConn injects failures in the data written by the server's session.
+//
+// It also removes the ;FW line from the handshake, since the CMS does not request
+// messages on behalf of anyone (a ;FW line would make the remote treat us as a P2P station).
+type faultConn struct {
+	net.Conn
+	faults Faults
+
+	handshakeDone bool
+	transferring  bool
+	nTransferred  int
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	switch {
+	case !c.handshakeDone && bytes.HasPrefix(p, []byte(";FW:")):
+		c.handshakeDone = true
+		if idx := bytes.IndexByte(p, '\r'); idx >= 0 {
+			if _, err := c.Conn.Write(p[idx+1:]); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case c.faults.ErrorLine != "" && len(p) > 0 && p[0] == 'F':
+		fmt.Fprintf(c.Conn, "*** %s\r", c.faults.ErrorLine)
+		c.Conn.Close()
+		return 0, errors.New(c.faults.ErrorLine)
+	case c.faults.DropAfter > 0 && len(p) > 0 && p[0] == 0x01: // SOH: Start of message transfer
+		c.transferring = true
+	}
+
+	if !c.transferring || c.faults.DropAfter <= 0 {
+		return c.Conn.Write(p)
+	}
+
+	if c.nTransferred+len(p) < c.faults.DropAfter {
+		c.nTransferred += len(p)
+		return c.Conn.Write(p)
+	}
+
+	n, _ := c.Conn.Write(p[:c.faults.DropAfter-c.nTransferred])
+	c.nTransferred += n
+	c.Conn.Close()
+	return n, errLinkDropped
+}
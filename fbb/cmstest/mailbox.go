@@ -0,0 +1,119 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package cmstest
+
+import (
+	"sync"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+// Mailbox holds the messages of a single call sign.
+type Mailbox struct {
+	mu      sync.Mutex
+	pending []*fbb.Message // Waiting to be picked up by the call sign
+	sent    []*fbb.Message // Received from the call sign
+}
+
+// Add queues one or more messages for pickup by the mailbox' call sign.
+func (m *Mailbox) Add(msgs ...*fbb.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, msgs...)
+}
+
+// Pending returns the messages waiting to be picked up by the mailbox' call sign.
+func (m *Mailbox) Pending() []*fbb.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*fbb.Message(nil), m.pending...)
+}
+
+// Sent returns the messages the mailbox' call sign has sent to the CMS.
+func (m *Mailbox) Sent() []*fbb.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*fbb.Message(nil), m.sent...)
+}
+
+func (m *Mailbox) addSent(msg *fbb.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+}
+
+func (m *Mailbox) remove(MID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, msg := range m.pending {
+		if msg.MID() == MID {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// handler is the fbb.MBoxHandler of a single session with the CMS.
+type handler struct {
+	srv        *Server
+	remoteCall string
+	duplicates bool
+}
+
+func (h *handler) Prepare() error { return nil }
+
+func (h *handler) GetOutbound(fw ...fbb.Address) []*fbb.Message {
+	if len(fw) == 0 {
+		fw = []fbb.Address{fbb.AddressFromString(h.remoteCall)}
+	}
+
+	var out []*fbb.Message
+	for _, addr := range fw {
+		for _, msg := range h.srv.Mailbox(addr.Addr).Pending() {
+			out = append(out, msg)
+			if h.duplicates {
+				out = append(out, msg)
+			}
+		}
+	}
+	return out
+}
+
+func (h *handler) SetSent(MID string, rejected bool) {
+	h.srv.mu.Lock()
+	mailboxes := make([]*Mailbox, 0, len(h.srv.mailboxes))
+	for _, mbox := range h.srv.mailboxes {
+		mailboxes = append(mailboxes, mbox)
+	}
+	h.srv.mu.Unlock()
+
+	for _, mbox := range mailboxes {
+		mbox.remove(MID)
+	}
+}
+
+func (h *handler) SetDeferred(MID string) {}
+
+func (h *handler) ProcessInbound(msgs ...*fbb.Message) error {
+	for _, msg := range msgs {
+		h.srv.Mailbox(h.remoteCall).addSent(msg)
+		h.srv.markSeen(msg.MID())
+
+		// Deliver to Winlink recipients. Internet recipients are only kept in the sender's mailbox.
+		for _, addr := range msg.Receivers() {
+			if addr.Proto == "" {
+				h.srv.Mailbox(addr.Addr).Add(msg)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *handler) GetInboundAnswer(p fbb.Proposal) fbb.ProposalAnswer {
+	if h.srv.seen(p.MID()) {
+		return fbb.Reject
+	}
+	return fbb.Accept
+}
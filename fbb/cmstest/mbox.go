@@ -0,0 +1,112 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package cmstest
+
+import (
+	"sync"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+// MBox is an in-memory fbb.MBoxHandler, for the client side of sessions in tests.
+//
+// Unlike mailbox.DirHandler, the messages are kept exactly as given and received.
+type MBox struct {
+	mu        sync.Mutex
+	inbox     map[string]*fbb.Message
+	outbox    map[string]*fbb.Message
+	processed int
+}
+
+// NewMBox returns a new MBox with the given outbound messages.
+func NewMBox(out ...*fbb.Message) *MBox {
+	mbox := &MBox{
+		inbox:  make(map[string]*fbb.Message),
+		outbox: make(map[string]*fbb.Message),
+	}
+	for _, msg := range out {
+		mbox.outbox[msg.MID()] = msg
+	}
+	return mbox
+}
+
+// NewMessage returns a private test message from the given call sign to the given receivers.
+func NewMessage(from string, to ...string) *fbb.Message {
+	msg := fbb.NewMessage(fbb.Private, from)
+	msg.AddTo(to...)
+	msg.SetSubject("Test")
+	msg.SetBody("Hello world")
+	return msg
+}
+
+// Inbox returns the received messages, by MID.
+func (m *MBox) Inbox() map[string]*fbb.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyMessages(m.inbox)
+}
+
+// Outbox returns the messages not yet sent, by MID.
+func (m *MBox) Outbox() map[string]*fbb.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyMessages(m.outbox)
+}
+
+// Processed returns the number of times ProcessInbound has been called with a message, including duplicates.
+func (m *MBox) Processed() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.processed
+}
+
+func (m *MBox) Prepare() error { return nil }
+
+func (m *MBox) GetOutbound(fw ...fbb.Address) []*fbb.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*fbb.Message, 0, len(m.outbox))
+	for _, msg := range m.outbox {
+		out = append(out, msg)
+	}
+	return out
+}
+
+func (m *MBox) SetSent(MID string, rejected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.outbox, MID)
+}
+
+func (m *MBox) SetDeferred(MID string) {}
+
+func (m *MBox) ProcessInbound(msgs ...*fbb.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range msgs {
+		m.inbox[msg.MID()] = msg
+		m.processed++
+	}
+	return nil
+}
+
+func (m *MBox) GetInboundAnswer(p fbb.Proposal) fbb.ProposalAnswer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.inbox[p.MID()]; ok {
+		return fbb.Reject
+	}
+	return fbb.Accept
+}
+
+var _ fbb.MBoxHandler = (*MBox)(nil)
+
+func copyMessages(msgs map[string]*fbb.Message) map[string]*fbb.Message {
+	cp := make(map[string]*fbb.Message, len(msgs))
+	for mid, msg := range msgs {
+		cp[mid] = msg
+	}
+	return cp
+}
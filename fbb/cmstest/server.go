@@ -0,0 +1,231 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package cmstest provides an in-process stand-in for a Winlink CMS, for use in integration tests.
+//
+// The Server accepts telnet connections (see transport/telnet), challenges the remote with a
+// secure login (for call signs with a password), and exchanges messages with the per call sign
+// mailboxes. Failures can be injected to test error handling of the connecting station.
+//
+// Example:
+//
+//	srv, _ := cmstest.NewServer()
+//	defer srv.Close()
+//
+//	srv.SetPassword("N0CALL", "secret")
+//	srv.Mailbox("N0CALL").Add(msg)
+//
+//	conn, _ := telnet.Dial(srv.Addr(), "N0CALL", telnet.CMSPassword)
+//	session := fbb.NewSession("N0CALL", telnet.CMSTargetCall, "JO39EQ", mbox)
+//	session.SetSecureLoginHandleFunc(func(fbb.Address) (string, error) { return "secret", nil })
+//	session.Exchange(conn)
+package cmstest
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/transport/telnet"
+)
+
+// The call sign and user agent the server identifies as.
+const mycall = "WL2K"
+
+var userAgent = fbb.UserAgent{Name: "WL2K", Version: "5.0"}
+
+// The time allowed for a session to complete.
+const sessionTimeout = time.Minute
+
+// Faults holds the failures to inject in sessions with the Server.
+type Faults struct {
+	// RejectLogin causes the secure login to be rejected regardless of the password.
+	RejectLogin bool
+
+	// DropAfter causes the link to be dropped when the given number of bytes of a message
+	// transfer from the server has been sent (0 means never).
+	DropAfter int
+
+	// ErrorLine causes the server to send "*** <ErrorLine>" and disconnect instead of
+	// the first protocol command (proposals, proposal answer or FF/FQ) after the handshake.
+	ErrorLine string
+
+	// DuplicateProposals causes every message to be proposed twice in the same block,
+	// like some Radio Only gateways does.
+	DuplicateProposals bool
+}
+
+// Server is a fake Winlink CMS listening for telnet connections on the loopback interface.
+type Server struct {
+	ln     net.Listener
+	srv    *fbb.Server
+	served chan struct{} // Closed when srv.Serve has returned
+	log    *log.Logger
+
+	mu        sync.Mutex
+	mailboxes map[string]*Mailbox
+	passwords map[string]string
+	seenMIDs  map[string]bool
+	faults    Faults
+	errs      []error
+}
+
+// NewServer starts a new Server listening on a random port on the loopback interface.
+func NewServer() (*Server, error) {
+	ln, err := telnet.Listen("127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:        ln,
+		served:    make(chan struct{}),
+		log:       log.New(ioutil.Discard, "", 0),
+		mailboxes: make(map[string]*Mailbox),
+		passwords: make(map[string]string),
+		seenMIDs:  make(map[string]bool),
+	}
+	s.srv = &fbb.Server{
+		Mycall:    mycall,
+		Handler:   s.newSession,
+		Configure: s.configure,
+		OnResult:  s.result,
+		Logger:    s.log,
+	}
+
+	go func() {
+		defer close(s.served)
+		s.srv.Serve(ln)
+	}()
+	return s, nil
+}
+
+// Addr returns the address (host:port) the server is listening on.
+func (s *Server) Addr() string { return s.ln.Addr().String() }
+
+// SetLogger sets the logger used by the server's sessions. Logs are discarded by default.
+func (s *Server) SetLogger(logger *log.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = logger
+}
+
+// SetPassword sets the secure login password of the given call sign.
+//
+// Call signs with a password are challenged with a secure login when connecting.
+func (s *Server) SetPassword(callsign, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwords[strings.ToUpper(callsign)] = password
+}
+
+// SetFaults sets the failures to inject in subsequent sessions.
+func (s *Server) SetFaults(f Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+}
+
+// Mailbox returns the mailbox of the given call sign.
+func (s *Server) Mailbox(callsign string) *Mailbox {
+	callsign = strings.ToUpper(callsign)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mbox, ok := s.mailboxes[callsign]
+	if !ok {
+		mbox = new(Mailbox)
+		s.mailboxes[callsign] = mbox
+	}
+	return mbox
+}
+
+// Errors returns the errors returned by the server's sessions so far.
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// Close stops the server, aborting all active sessions.
+//
+// Close blocks until all sessions has returned.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.srv.Shutdown(ctx)
+	<-s.served
+	return nil
+}
+
+// passwordLookup implements fbb.PasswordLookup using the server's passwords.
+type passwordLookup struct {
+	srv    *Server
+	reject bool
+}
+
+func (l passwordLookup) LookupPassword(addr fbb.Address) (string, bool) {
+	if l.reject {
+		return "", false
+	}
+
+	l.srv.mu.Lock()
+	defer l.srv.mu.Unlock()
+	password, ok := l.srv.passwords[addr.Addr]
+	return password, ok
+}
+
+// newSession returns the handler of a new session with the given remote.
+func (s *Server) newSession(remoteCall string) (fbb.MBoxHandlerV2, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fbb.AdaptMBoxHandler(&handler{
+		srv:        s,
+		remoteCall: remoteCall,
+		duplicates: s.faults.DuplicateProposals,
+	}), nil
+}
+
+// configure configures a new session, returning the connection with the server's faults injected.
+func (s *Server) configure(session *fbb.Session, conn net.Conn) net.Conn {
+	s.mu.Lock()
+	faults, logger := s.faults, s.log
+	_, hasPassword := s.passwords[session.Targetcall()]
+	s.mu.Unlock()
+
+	session.SetUserAgent(userAgent)
+	session.SetLogger(logger)
+	if hasPassword || faults.RejectLogin {
+		session.SetPasswordLookup(passwordLookup{srv: s, reject: faults.RejectLogin})
+	}
+
+	conn.SetDeadline(time.Now().Add(sessionTimeout))
+	return &faultConn{Conn: conn, faults: faults}
+}
+
+func (s *Server) result(r fbb.SessionResult) {
+	if r.Err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, r.Err)
+}
+
+func (s *Server) markSeen(MID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seenMIDs[MID] = true
+}
+
+func (s *Server) seen(MID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seenMIDs[MID]
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package cmstest
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/transport/telnet"
+)
+
+const (
+	mycallTest   = "N0CALL"
+	passwordTest = "secret"
+)
+
+func TestExchange(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	in := NewMessage("LA5NTA", mycallTest)
+	srv.Mailbox(mycallTest).Add(in)
+
+	out := NewMessage(mycallTest, "LA5NTA", "foo@example.com")
+	mbox, err := exchange(srv, out)
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+
+	if _, ok := mbox.Inbox()[in.MID()]; !ok {
+		t.Errorf("Pending message not received")
+	}
+	if len(srv.Mailbox(mycallTest).Pending()) != 0 {
+		t.Errorf("Received message still pending")
+	}
+	if len(mbox.Outbox()) != 0 {
+		t.Errorf("Outbound message not sent")
+	}
+	if sent := srv.Mailbox(mycallTest).Sent(); len(sent) != 1 || sent[0].MID() != out.MID() {
+		t.Errorf("Unexpected sent messages: %v", sent)
+	}
+	if pending := srv.Mailbox("LA5NTA").Pending(); len(pending) != 1 || pending[0].MID() != out.MID() {
+		t.Errorf("Sent message not delivered to recipient")
+	}
+	if errs := srv.Errors(); len(errs) > 0 {
+		t.Errorf("Unexpected server errors: %v", errs)
+	}
+}
+
+func TestRejectLogin(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	// Wrong password
+	srv.SetPassword(mycallTest, "wrong")
+	if _, err := exchange(srv); !fbb.IsLoginFailure(err) {
+		t.Errorf("Expected login failure, got %v", err)
+	}
+
+	// Correct password, injected failure
+	srv.SetPassword(mycallTest, passwordTest)
+	srv.SetFaults(Faults{RejectLogin: true})
+
+	if _, err := exchange(srv); !fbb.IsLoginFailure(err) {
+		t.Errorf("Expected login failure, got %v", err)
+	}
+}
+
+func TestDropAfter(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	srv.SetFaults(Faults{DropAfter: 100})
+
+	in := NewMessage("LA5NTA", mycallTest)
+	in.SetBody(strings.Repeat("Lorem ipsum dolor sit amet. ", 100))
+	srv.Mailbox(mycallTest).Add(in)
+
+	mbox, err := exchange(srv)
+	if err == nil {
+		t.Errorf("Expected error")
+	}
+	if len(mbox.Inbox()) != 0 {
+		t.Errorf("Message unexpectedly received")
+	}
+	if len(srv.Mailbox(mycallTest).Pending()) != 1 {
+		t.Errorf("Expected message to be pending")
+	}
+}
+
+func TestErrorLine(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	srv.SetFaults(Faults{ErrorLine: "Unable to process request"})
+
+	if _, err := exchange(srv); err == nil || err.Error() != "Unable to process request" {
+		t.Errorf("Expected remote error, got %v", err)
+	}
+}
+
+func TestDuplicateProposals(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	srv.SetFaults(Faults{DuplicateProposals: true})
+
+	in := NewMessage("LA5NTA", mycallTest)
+	srv.Mailbox(mycallTest).Add(in)
+
+	mbox, err := exchange(srv)
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if len(mbox.Inbox()) != 1 || mbox.Processed() != 1 {
+		t.Errorf("Expected message to be received once, got %d", mbox.Processed())
+	}
+	if len(srv.Mailbox(mycallTest).Pending()) != 0 {
+		t.Errorf("Received message still pending")
+	}
+}
+
+func newTestServer(t *testing.T) *Server {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetPassword(mycallTest, passwordTest)
+	return srv
+}
+
+// exchange connects to srv and exchanges messages with a station holding the given outbound messages.
+func exchange(srv *Server, out ...*fbb.Message) (*MBox, error) {
+	conn, err := telnet.Dial(srv.Addr(), mycallTest, telnet.CMSPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	mbox := NewMBox(out...)
+	s := fbb.NewSession(mycallTest, telnet.CMSTargetCall, "JO39EQ", mbox)
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.SetSecureLoginHandleFunc(func(fbb.Address) (string, error) { return passwordTest, nil })
+
+	_, err = s.Exchange(conn)
+	return mbox, err
+}
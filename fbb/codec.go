@@ -0,0 +1,204 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/la5nta/wl2k-go/lzhuf"
+)
+
+// A Codec compresses and decompresses B2 messages transferred with a given proposal code.
+//
+// Codecs are registered with RegisterCodec, and enabled per session with Session.SetCodecs.
+type Codec struct {
+	Name string   // Name of the codec (e.g. gzip)
+	Code PropCode // The proposal code of messages compressed with this codec (e.g. 'D')
+
+	// The SID flag advertising support for the codec (e.g. "G").
+	//
+	// An empty flag means that the codec is implied by B2F (lzhuf).
+	SIDFlag string
+
+	// NewWriter returns a WriteCloser compressing data written to it. The data must be
+	// flushed to w when the writer is closed.
+	NewWriter func(w io.Writer) io.WriteCloser
+
+	// NewReader returns a ReadCloser decompressing data from r.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// The built-in codecs.
+var (
+	// LZHUFCodec is the lzhuf codec mandated by B2F. It is always enabled.
+	LZHUFCodec = Codec{
+		Name:      "lzhuf",
+		Code:      Wl2kProposal,
+		NewWriter: func(w io.Writer) io.WriteCloser { return lzhuf.NewB2Writer(w) },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return lzhuf.NewB2Reader(r) },
+	}
+
+	// GzipCodec is an experimental codec using gzip compression.
+	GzipCodec = Codec{
+		Name:    "gzip",
+		Code:    GzipProposal,
+		SIDFlag: sGzip,
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			z, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+			return z
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[PropCode]Codec{
+		LZHUFCodec.Code: LZHUFCodec,
+		GzipCodec.Code:  GzipCodec,
+	}
+)
+
+// reservedCodes are the proposal codes that can't be used by codecs: the legacy FBB
+// proposals (A and B) and the commands sharing the proposal line prefix (FF, FQ, FS and F>).
+const reservedCodes = "ABFQS>"
+
+// reservedSIDFlags are the SID flags of the protocol features (see localSID).
+const reservedSIDFlags = sAckForPM + sFBBasic + sFBComp0 + sHL + sMID + sCompBatchF + sI + sBID + "0123456789"
+
+// RegisterCodec makes a codec available for use in sessions (see Session.SetCodecs).
+//
+// If RegisterCodec is called twice with the same proposal code or SID flag, with a proposal
+// code reserved by the protocol (A, B, F, Q, S and >), or with a SID flag used by the protocol,
+// it panics.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	switch _, dup := codecs[c.Code]; {
+	case c.Code <= ' ' || c.Code > '~' || strings.ContainsRune(reservedCodes, rune(c.Code)):
+		panic(fmt.Sprintf("fbb: RegisterCodec with reserved proposal code %c", c.Code))
+	case len(c.SIDFlag) > 1 || (c.SIDFlag != "" && strings.Contains(reservedSIDFlags, c.SIDFlag)):
+		panic(fmt.Sprintf("fbb: RegisterCodec with reserved SID flag %q", c.SIDFlag))
+	case c.NewWriter == nil || c.NewReader == nil:
+		panic("fbb: RegisterCodec with nil writer or reader")
+	case dup:
+		panic(fmt.Sprintf("fbb: RegisterCodec called twice for proposal code %c", c.Code))
+	}
+	for _, other := range codecs {
+		if c.SIDFlag != "" && other.SIDFlag == c.SIDFlag {
+			panic(fmt.Sprintf("fbb: RegisterCodec called twice for SID flag %s", c.SIDFlag))
+		}
+	}
+	codecs[c.Code] = c
+}
+
+// LookupCodec returns the codec registered for the given proposal code.
+func LookupCodec(code PropCode) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[code]
+	return c, ok
+}
+
+// isProposalCode returns true if code is the code of a B2F or FBB proposal (e.g. FC).
+func isProposalCode(code PropCode) bool {
+	if code == BasicProposal || code == AsciiProposal {
+		return true
+	}
+	_, ok := LookupCodec(code)
+	return ok
+}
+
+// lookupCodec returns the codec registered for the given proposal code, falling back to lzhuf.
+func lookupCodec(code PropCode) Codec {
+	if c, ok := LookupCodec(code); ok {
+		return c
+	}
+	return LZHUFCodec
+}
+
+// CodecsFromEnv returns the proposal codes of the codecs enabled by environment variables.
+//
+// Gzip (D) is enabled if GZIP_EXPERIMENT=1. This is used as the default by NewSession.
+func CodecsFromEnv() []PropCode {
+	if os.Getenv("GZIP_EXPERIMENT") == "1" {
+		return []PropCode{GzipProposal}
+	}
+	return nil
+}
+
+// SetCodecs sets the compression codecs (by proposal code) enabled for this session, in addition
+// to lzhuf (C) which is always enabled.
+//
+// Outbound messages are compressed with every enabled codec supported by the remote, and the
+// smallest result is proposed. The default is given by CodecsFromEnv.
+//
+// An error is returned if any of the codecs is not registered.
+func (s *Session) SetCodecs(codes ...PropCode) error {
+	enabled := []PropCode{Wl2kProposal}
+	for _, code := range codes {
+		if _, ok := LookupCodec(code); !ok {
+			return fmt.Errorf("Unknown codec %c", code)
+		}
+		if code != Wl2kProposal {
+			enabled = append(enabled, code)
+		}
+	}
+	s.codecs = enabled
+	return nil
+}
+
+// codecSID returns the SID flags advertising the codecs enabled for this session.
+func (s *Session) codecSID() string {
+	var flags []string
+	for _, code := range s.codecs {
+		if c := lookupCodec(code); c.SIDFlag != "" {
+			flags = append(flags, c.SIDFlag)
+		}
+	}
+	sort.Strings(flags)
+	return strings.Join(flags, "")
+}
+
+// remoteCodecs returns the proposal codes of the enabled codecs supported by the remote.
+func (s *Session) remoteCodecs() []PropCode {
+	var supported []PropCode
+	for _, code := range s.codecs {
+		if c := lookupCodec(code); c.SIDFlag == "" || s.remoteSID.Has(c.SIDFlag) {
+			supported = append(supported, code)
+		}
+	}
+	return supported
+}
+
+// smallestProposal returns the smallest proposal of the message, compressed with each of the given codecs.
+func smallestProposal(m *Message, codes []PropCode) (*Proposal, error) {
+	var smallest *Proposal
+	for _, code := range codes {
+		prop, err := m.Proposal(code)
+		if err != nil {
+			return nil, err
+		}
+		if smallest == nil || prop.compressedSize < smallest.compressedSize {
+			smallest = prop
+		}
+	}
+	return smallest, nil
+}
+
+func codecNames(codes []PropCode) string {
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		names[i] = lookupCodec(code).Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// storeCodec is a codec that does not compress at all (larger than lzhuf for text, but smaller for random data).
+var storeCodec = Codec{
+	Name:      "store",
+	Code:      'E',
+	SIDFlag:   "E",
+	NewWriter: func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+	NewReader: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(r), nil },
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func init() { RegisterCodec(storeCodec) }
+
+func TestSetCodecs(t *testing.T) {
+	s := NewSession("LA5NTA", "LA1B", "JO39EQ", nil)
+	if sid := s.localSID(); sid != localSID {
+		t.Errorf("Unexpected default SID %s", sid)
+	}
+
+	if err := s.SetCodecs(GzipProposal, storeCodec.Code); err != nil {
+		t.Fatal(err)
+	}
+	if sid := s.localSID(); sid != "B2FHMEG$" {
+		t.Errorf("Unexpected SID %s", sid)
+	}
+
+	s.remoteSID = "B2FHMG$"
+	if codecs := s.remoteCodecs(); len(codecs) != 2 || codecs[0] != Wl2kProposal || codecs[1] != GzipProposal {
+		t.Errorf("Unexpected codecs supported by remote: %q", codecs)
+	}
+
+	if err := s.SetCodecs('Q'); err == nil {
+		t.Errorf("Expected error for unknown codec")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected panic on duplicate codec")
+			}
+		}()
+		RegisterCodec(storeCodec)
+	}()
+
+	for _, c := range []Codec{
+		{Name: "answer", Code: 'S', NewWriter: storeCodec.NewWriter, NewReader: storeCodec.NewReader},
+		{Name: "prompt", Code: '>', NewWriter: storeCodec.NewWriter, NewReader: storeCodec.NewReader},
+		{Name: "ack", Code: 'K', SIDFlag: sAckForPM, NewWriter: storeCodec.NewWriter, NewReader: storeCodec.NewReader},
+		{Name: "dup-flag", Code: 'K', SIDFlag: sGzip, NewWriter: storeCodec.NewWriter, NewReader: storeCodec.NewReader},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic registering codec %s", c.Name)
+				}
+			}()
+			RegisterCodec(c)
+		}()
+	}
+}
+
+func TestCustomCodecExchange(t *testing.T) {
+	// Random data doesn't compress, so the store codec is smaller than lzhuf
+	data := make([]byte, 2000)
+	rand.New(rand.NewSource(1)).Read(data)
+	msg := testMessage("N0CALL", "LA5NTA", 10)
+	msg.AddFile(NewFile("random.bin", data))
+
+	mbox := newMemMBox()
+	var events eventRecorder
+
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", mbox)
+	master.SetLogger(discardLogger)
+	master.SetCodecs(storeCodec.Code)
+	master.SetEventObserver(&events)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(msg))
+	client.SetLogger(discardLogger)
+	client.SetCodecs(storeCodec.Code)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+	if props := events.inboundProposals(); len(props) != 1 || props[0].code != storeCodec.Code {
+		t.Errorf("Expected one proposal with code %c, got %v", storeCodec.Code, props)
+	}
+	got, ok := mbox.in[msg.MID()]
+	if !ok {
+		t.Fatalf("Message not received")
+	}
+	if files := got.Files(); len(files) != 1 || !bytes.Equal(files[0].Data(), data) {
+		t.Errorf("Unexpected attachment received")
+	}
+}
+
+func TestCodecsFromEnv(t *testing.T) {
+	defer os.Setenv("GZIP_EXPERIMENT", os.Getenv("GZIP_EXPERIMENT"))
+
+	os.Setenv("GZIP_EXPERIMENT", "1")
+	if sid := NewSession("LA5NTA", "LA1B", "JO39EQ", nil).localSID(); sid != "B2FHMG$" {
+		t.Errorf("Unexpected SID with GZIP_EXPERIMENT=1: %s", sid)
+	}
+
+	os.Setenv("GZIP_EXPERIMENT", "")
+	if sid := NewSession("LA5NTA", "LA1B", "JO39EQ", nil).localSID(); sid != localSID {
+		t.Errorf("Unexpected SID without GZIP_EXPERIMENT: %s", sid)
+	}
+}
+
+func TestSmallestCodec(t *testing.T) {
+	text := testMessage("N0CALL", "LA5NTA", 0)
+	text.SetBody(strings.Repeat("Lorem ipsum dolor sit amet. ", 200))
+
+	gz, _ := text.Proposal(GzipProposal)
+	lz, _ := text.Proposal(Wl2kProposal)
+	smallest := PropCode(Wl2kProposal)
+	if gz.compressedSize < lz.compressedSize {
+		smallest = GzipProposal
+	}
+
+	tests := []struct {
+		client, master []PropCode
+		expect         PropCode
+	}{
+		{nil, nil, Wl2kProposal},
+		{[]PropCode{storeCodec.Code}, []PropCode{storeCodec.Code}, Wl2kProposal},
+		{[]PropCode{GzipProposal}, nil, Wl2kProposal}, // Not supported by master
+		{[]PropCode{GzipProposal, storeCodec.Code}, []PropCode{GzipProposal}, smallest},
+	}
+
+	for i, test := range tests {
+		mbox := newMemMBox()
+		var events eventRecorder
+
+		masterConn, clientConn := net.Pipe()
+		master := NewSession("LA5NTA", "N0CALL", "JO39EQ", mbox)
+		master.SetLogger(discardLogger)
+		master.SetCodecs(test.master...)
+		client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(text))
+		client.SetLogger(discardLogger)
+		client.SetCodecs(test.client...)
+		client.SetEventObserver(&events)
+
+		if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+			t.Fatalf("%d: Exchange failed: %v, %v", i, masterErr, clientErr)
+		}
+		if _, ok := mbox.in[text.MID()]; !ok {
+			t.Errorf("%d: Message not received", i)
+		}
+		for _, e := range events {
+			if e.Type == EventProposal && e.Proposal.code != test.expect {
+				t.Errorf("%d: Expected proposal code %c, got %c", i, test.expect, e.Proposal.code)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/la5nta/wl2k-go/lzhuf"
+)
+
+// Phase is a phase of the B2F session, used to give context to protocol errors.
+type Phase int
+
+const (
+	PhaseHandshake Phase = iota // Exchange of SIDs, forwarder addresses and secure login
+	PhaseProposals              // Exchange of proposals and proposal answers
+	PhaseTransfer               // Transfer of (compressed) messages
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseHandshake:
+		return "handshake"
+	case PhaseProposals:
+		return "proposals"
+	case PhaseTransfer:
+		return "transfer"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// RemoteError is an error reported by the remote on an error line (e.g. "*** Unable to process request").
+type RemoteError struct {
+	Line string // The raw line, as received
+}
+
+// Message returns the error message, i.e. the text following the last '*'.
+func (e *RemoteError) Message() string {
+	return strings.TrimSpace(e.Line[strings.LastIndex(e.Line, "*")+1:])
+}
+
+func (e *RemoteError) Error() string { return e.Message() }
+
+// ProtocolError is returned when the remote violates the protocol.
+type ProtocolError struct {
+	Phase Phase  // The session phase in which the error occurred
+	Line  string // The offending line (if any)
+	Err   error  // The underlying error
+}
+
+func (e *ProtocolError) Error() string { return e.Err.Error() }
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+// LoginError is returned when the secure login failed.
+//
+// It is returned by the master when the remote's secure login response is not valid (Err is
+// ErrSecureLoginFailed), and by the client when the remote reports a failed login (Err is a
+// *RemoteError). In both cases errors.Is(err, ErrSecureLoginFailed) is true.
+type LoginError struct {
+	Addr Address // The address the login failed for (if known)
+	Err  error   // The underlying error
+}
+
+func (e *LoginError) Error() string { return e.Err.Error() }
+
+func (e *LoginError) Unwrap() error { return e.Err }
+
+func (e *LoginError) Is(target error) bool { return target == ErrSecureLoginFailed }
+
+// ChecksumError is returned when a received proposal block or message fails checksum verification.
+//
+// Decompression checksum errors wraps lzhuf.ErrChecksum, so errors.Is(err, lzhuf.ErrChecksum) is
+// true for those.
+type ChecksumError struct {
+	MID string // The MID of the message (empty for proposal blocks)
+	Err error  // The underlying error (if any)
+}
+
+func (e *ChecksumError) Error() string {
+	switch {
+	case e.Err != nil && e.MID != "":
+		return fmt.Sprintf("%s: %s", e.MID, e.Err)
+	case e.Err != nil:
+		return e.Err.Error()
+	case e.MID != "":
+		return fmt.Sprintf("Bad checksum (%s)", e.MID)
+	default:
+		return "Bad checksum"
+	}
+}
+
+func (e *ChecksumError) Unwrap() error { return e.Err }
+
+// protocolError returns a *ProtocolError with the given phase and offending line, formatted according to a format specifier.
+func protocolError(phase Phase, line string, format string, a ...interface{}) error {
+	return &ProtocolError{Phase: phase, Line: line, Err: fmt.Errorf(format, a...)}
+}
+
+// checksumError wraps decompression checksum errors as *ChecksumError.
+func checksumError(mid string, err error) error {
+	if errors.Is(err, lzhuf.ErrChecksum) {
+		return &ChecksumError{MID: mid, Err: err}
+	}
+	return err
+}
+
+func isLoginFailureText(str string) bool {
+	return strings.Contains(strings.ToLower(str), "secure login failed")
+}
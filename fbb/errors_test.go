@@ -0,0 +1,103 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/lzhuf"
+)
+
+func TestRemoteError(t *testing.T) {
+	line := "*** Unable to process request"
+	var remoteErr *RemoteError
+	if err := errLine(line); !errors.As(err, &remoteErr) || remoteErr.Line != line {
+		t.Errorf("Expected *RemoteError with raw line, got %#v", err)
+	} else if IsLoginFailure(err) {
+		t.Errorf("Unexpected login failure: %s", err)
+	}
+
+	line = "*** " + ErrSecureLoginFailed.Error()
+	err := errLine(line)
+	var loginErr *LoginError
+	switch {
+	case !errors.As(err, &loginErr):
+		t.Errorf("Expected *LoginError, got %#v", err)
+	case !errors.Is(err, ErrSecureLoginFailed) || !IsLoginFailure(err):
+		t.Errorf("Expected error to be ErrSecureLoginFailed: %s", err)
+	case !errors.As(err, &remoteErr) || remoteErr.Line != line:
+		t.Errorf("Expected login error to wrap *RemoteError")
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	tests := []struct {
+		lines   []string
+		errLine string
+	}{
+		{[]string{"FZ\r"}, "FZ"},
+		{[]string{"FC EM TEST_MID 100 50 0\r", "F> 00\r"}, "F> 00"},
+	}
+
+	for i, test := range tests {
+		client, srv := net.Pipe()
+		srv.SetDeadline(time.Now().Add(10 * time.Second))
+		go io.Copy(ioutil.Discard, srv)
+
+		errs := make(chan error)
+		go func() {
+			s := NewSession("LA5NTA", "LA1B", "JO39EQ", newMemMBox())
+			s.SetLogger(discardLogger)
+			_, err := s.Exchange(client)
+			errs <- err
+		}()
+
+		fmt.Fprint(srv, "[WL2K-5.0-B2FWIHJM$]\rCMS>\r")
+		for _, line := range test.lines {
+			fmt.Fprint(srv, line)
+		}
+
+		err := <-errs
+		srv.Close()
+
+		var protoErr *ProtocolError
+		switch {
+		case !errors.As(err, &protoErr):
+			t.Errorf("%d: Expected *ProtocolError, got %#v", i, err)
+		case protoErr.Phase != PhaseProposals || protoErr.Line != test.errLine:
+			t.Errorf("%d: Unexpected phase (%s) or line (%q)", i, protoErr.Phase, protoErr.Line)
+		}
+	}
+}
+
+func TestChecksumError(t *testing.T) {
+	prop, err := testMessage("LA5NTA", "LA1B", 100).Proposal(Wl2kProposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop.compressedData[0] ^= 0xff // Corrupt the crc
+
+	var checksumErr *ChecksumError
+	_, err = prop.Message()
+	switch {
+	case !errors.Is(err, lzhuf.ErrChecksum):
+		t.Errorf("Expected lzhuf.ErrChecksum, got %v", err)
+	case !errors.As(err, &checksumErr) || checksumErr.MID != prop.MID():
+		t.Errorf("Expected *ChecksumError for %s, got %#v", prop.MID(), err)
+	}
+
+	if data, err := prop.ReadData(); data != nil || !errors.As(err, &checksumErr) {
+		t.Errorf("Expected *ChecksumError from ReadData, got %#v", err)
+	}
+	if data := prop.Data(); data != nil {
+		t.Errorf("Expected no data from corrupted proposal")
+	}
+}
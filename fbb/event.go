@@ -0,0 +1,91 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import "time"
+
+// An EventObserver is notified about the protocol events of an exchange as they happen.
+//
+// ObserveEvent is called synchronously from the exchange, and should return quickly.
+type EventObserver interface {
+	ObserveEvent(e Event)
+}
+
+// The EventObserverFunc type is an adapter to allow the use of ordinary functions as EventObserver.
+type EventObserverFunc func(e Event)
+
+// ObserveEvent calls f(e).
+func (f EventObserverFunc) ObserveEvent(e Event) { f(e) }
+
+// EventType identifies the protocol phase of an Event.
+type EventType int
+
+// The different event types.
+const (
+	EventHandshake         EventType = iota // A handshake line (other than the SID) was sent or received.
+	EventRemoteSID                          // The remote's SID was received (see Event.SID).
+	EventProposal                           // A message proposal was sent or received (see Event.Proposal).
+	EventProposalsEnd                       // The proposal block was terminated (F>). Event.Proposals holds the block.
+	EventProposalAnswer                     // A proposal answer (FS) was sent or received. Event.Proposals holds the answered proposals.
+	EventTransferStarted                    // The transfer of an accepted message started (see Event.Proposal).
+	EventTransferCompleted                  // The transfer of an accepted message completed (see Event.Proposal).
+	EventTurnover                           // No more messages (FF) was sent or received.
+	EventQuit                               // Quit (FQ) was sent or received.
+	EventError                              // An error line (***) was sent or received (see Event.Err).
+	EventQTC                                // The number of messages waiting was received from the remote (see Event.QTC).
+)
+
+var eventTypeNames = map[EventType]string{
+	EventHandshake:         "Handshake",
+	EventRemoteSID:         "RemoteSID",
+	EventProposal:          "Proposal",
+	EventProposalsEnd:      "ProposalsEnd",
+	EventProposalAnswer:    "ProposalAnswer",
+	EventTransferStarted:   "TransferStarted",
+	EventTransferCompleted: "TransferCompleted",
+	EventTurnover:          "Turnover",
+	EventQuit:              "Quit",
+	EventError:             "Error",
+	EventQTC:               "QTC",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Event holds information about a protocol event in an exchange.
+type Event struct {
+	Type EventType
+	When time.Time
+
+	// Outbound is true if the event originates from this end of the session (e.g. a line we sent).
+	Outbound bool
+
+	// The raw protocol line (without line terminator), if the event corresponds to a protocol line.
+	Line string
+
+	SID       string      // The remote's SID codes (EventRemoteSID).
+	Proposal  *Proposal   // The proposal (EventProposal, EventTransferStarted and EventTransferCompleted).
+	Proposals []*Proposal // The proposal block (EventProposalsEnd and EventProposalAnswer).
+	Err       error       // The error (EventError).
+	QTC       int         // The number of messages the remote has waiting for us (EventQTC).
+}
+
+// SetEventObserver sets the observer notified about protocol events during the exchange.
+func (s *Session) SetEventObserver(o EventObserver) { s.observer = o }
+
+// event notifies the observer (if any) about the given event.
+func (s *Session) event(e Event) {
+	if s.observer == nil {
+		return
+	}
+	if e.When.IsZero() {
+		e.When = time.Now()
+	}
+	s.observer.ObserveEvent(e)
+}
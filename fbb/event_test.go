@@ -0,0 +1,111 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+type eventRecorder []Event
+
+func (r *eventRecorder) ObserveEvent(e Event) { *r = append(*r, e) }
+
+// types returns the recorded event types, prefixed with > for outbound and < for inbound events.
+func (r eventRecorder) types() []string {
+	types := make([]string, len(r))
+	for i, e := range r {
+		dir := "<"
+		if e.Outbound {
+			dir = ">"
+		}
+		types[i] = dir + e.Type.String()
+	}
+	return types
+}
+
+func TestEventObserver(t *testing.T) {
+	msg := testMessage("N0CALL", "LA5NTA", 500)
+
+	var masterEvents, clientEvents eventRecorder
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", newMemMBox())
+	master.SetLogger(discardLogger)
+	master.SetEventObserver(&masterEvents)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(msg))
+	client.SetLogger(discardLogger)
+	client.SetEventObserver(&clientEvents)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	expectEvents(t, "client", clientEvents,
+		"<Handshake", "<RemoteSID", "<Handshake",
+		">Handshake", ">Handshake", ">Handshake",
+		">Proposal", ">ProposalsEnd", "<ProposalAnswer",
+		">TransferStarted", ">TransferCompleted",
+		"<Turnover", ">Quit",
+	)
+	expectEvents(t, "master", masterEvents,
+		">Handshake", ">Handshake", ">Handshake",
+		"<Handshake", "<RemoteSID", "<Handshake",
+		"<Proposal", "<ProposalsEnd", ">ProposalAnswer",
+		"<TransferStarted", "<TransferCompleted",
+		">Turnover", "<Quit",
+	)
+
+	for _, e := range clientEvents {
+		if e.When.IsZero() {
+			t.Errorf("%s: Missing timestamp", e.Type)
+		}
+		switch e.Type {
+		case EventRemoteSID:
+			if e.SID != localSID || e.Line != fmt.Sprintf("[wl2kgo-0.1a-%s]", localSID) {
+				t.Errorf("Unexpected SID event: %+v", e)
+			}
+		case EventProposal, EventTransferCompleted:
+			if e.Proposal == nil || e.Proposal.MID() != msg.MID() {
+				t.Errorf("%s: Unexpected proposal %v", e.Type, e.Proposal)
+			}
+		case EventProposalAnswer:
+			if e.Line != "FS +" || len(e.Proposals) != 1 || e.Proposals[0].answer != Accept {
+				t.Errorf("Unexpected proposal answer event: %+v", e)
+			}
+		}
+	}
+}
+
+func TestEventObserverRemoteError(t *testing.T) {
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", newMemMBox())
+	master.SetLogger(discardLogger)
+	master.SetPasswordLookup(passwordMap{})
+
+	var events eventRecorder
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox())
+	client.SetLogger(discardLogger)
+	client.SetEventObserver(&events)
+	client.SetSecureLoginHandleFunc(func(Address) (string, error) { return "secret", nil })
+
+	if _, clientErr := exchange(master, client, masterConn, clientConn); clientErr == nil {
+		t.Fatalf("Expected error")
+	}
+
+	// The remote's error line is received, and then echoed back before disconnecting.
+	expectEvents(t, "client", events[len(events)-2:], "<Error", ">Error")
+	if e := events[len(events)-2]; !IsLoginFailure(e.Err) || e.Line != "*** "+ErrSecureLoginFailed.Error() {
+		t.Errorf("Unexpected error event: %+v", e)
+	}
+}
+
+func expectEvents(t *testing.T, name string, events eventRecorder, expect ...string) {
+	t.Helper()
+	got := events.types()
+	if fmt.Sprint(got) != fmt.Sprint(expect) {
+		t.Errorf("%s: Unexpected events:\n got:    %v\n expect: %v", name, got, expect)
+	}
+}
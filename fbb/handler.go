@@ -0,0 +1,82 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import "context"
+
+// MBoxHandlerV2 is like MBoxHandler, but the outbound operations are context-aware and able to report errors.
+//
+// Use NewSessionV2 to create a session with a MBoxHandlerV2, or AdaptMBoxHandler to use a MBoxHandler
+// where a MBoxHandlerV2 is expected.
+type MBoxHandlerV2 interface {
+	InboundHandler
+	OutboundHandlerV2
+
+	// Prepare is called before any other operation in a session.
+	//
+	// The returned error can be used to indicate that the mailbox is
+	// not ready for a new session, the error will be forwarded to the
+	// remote node.
+	Prepare() error
+}
+
+// An OutboundHandlerV2 is like OutboundHandler, but context-aware and able to report errors.
+//
+// The context is the one given to Session.ExchangeContext. A non-nil error aborts the exchange,
+// and is forwarded (if possible) to the remote node.
+type OutboundHandlerV2 interface {
+	// GetOutbound should return all pending (outbound) messages addressed to (and only to) one of the fw addresses.
+	//
+	// No fw address implies that the remote node could be a Winlink CMS and all oubound
+	// messages can be delivered through the connected node.
+	GetOutbound(ctx context.Context, fw ...Address) ([]*Message, error)
+
+	// SetSent should mark the the message identified by MID as successfully sent.
+	//
+	// If rejected is true, it implies that the remote node has already received the message.
+	SetSent(ctx context.Context, MID string, rejected bool) error
+
+	// SetDeferred should mark the outbound message identified by MID as deferred.
+	//
+	// SetDeferred is called when the remote want's to receive the proposed message
+	// (see MID) later.
+	SetDeferred(ctx context.Context, MID string) error
+}
+
+// AdaptMBoxHandler returns a MBoxHandlerV2 using the given MBoxHandler. The returned handler never
+// returns errors from the outbound operations.
+//
+// Optional interfaces implemented by h (e.g. PartialStore) are still used when the returned handler is
+// given to NewSessionV2.
+func AdaptMBoxHandler(h MBoxHandler) MBoxHandlerV2 {
+	if h == nil {
+		return nil
+	}
+	return handlerAdapter{h}
+}
+
+type handlerAdapter struct{ MBoxHandler }
+
+func (a handlerAdapter) GetOutbound(ctx context.Context, fw ...Address) ([]*Message, error) {
+	return a.MBoxHandler.GetOutbound(fw...), nil
+}
+
+func (a handlerAdapter) SetSent(ctx context.Context, MID string, rejected bool) error {
+	a.MBoxHandler.SetSent(MID, rejected)
+	return nil
+}
+
+func (a handlerAdapter) SetDeferred(ctx context.Context, MID string) error {
+	a.MBoxHandler.SetDeferred(MID)
+	return nil
+}
+
+// optionalHandler returns the value that should be checked for optional handler interfaces (e.g. PartialStore).
+func optionalHandler(h MBoxHandlerV2) interface{} {
+	if a, ok := h.(handlerAdapter); ok {
+		return a.MBoxHandler
+	}
+	return h
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+var errMBox = errors.New("mailbox unavailable")
+
+// failingMBox is a MBoxHandlerV2 failing the given outbound operation.
+type failingMBox struct {
+	MBoxHandlerV2
+	op string
+}
+
+func (m failingMBox) GetOutbound(ctx context.Context, fw ...Address) ([]*Message, error) {
+	if m.op == "GetOutbound" {
+		return nil, errMBox
+	}
+	return m.MBoxHandlerV2.GetOutbound(ctx, fw...)
+}
+
+func (m failingMBox) SetSent(ctx context.Context, MID string, rejected bool) error {
+	if m.op == "SetSent" {
+		return errMBox
+	}
+	return m.MBoxHandlerV2.SetSent(ctx, MID, rejected)
+}
+
+func TestMBoxHandlerV2Error(t *testing.T) {
+	for _, op := range []string{"GetOutbound", "SetSent"} {
+		masterConn, clientConn := net.Pipe()
+
+		master := NewSession("LA5NTA", "N0CALL", "JO39EQ", newMemMBox())
+		master.SetLogger(discardLogger)
+
+		mbox := failingMBox{AdaptMBoxHandler(newMemMBox(testMessage("N0CALL", "LA5NTA", 100))), op}
+		client := NewSessionV2("N0CALL", "LA5NTA", "JO39EQ", mbox)
+		client.SetLogger(discardLogger)
+		var events eventRecorder
+		client.SetEventObserver(&events)
+
+		masterErr, clientErr := exchange(master, client, masterConn, clientConn)
+		if !errors.Is(clientErr, errMBox) {
+			t.Errorf("%s: Expected client to fail with mailbox error, got %v", op, clientErr)
+		}
+		if masterErr == nil {
+			t.Errorf("%s: Expected master to fail", op)
+		}
+
+		// The error is sent to the remote
+		if e := events[len(events)-1]; e.Type != EventError || !e.Outbound || !strings.HasPrefix(e.Line, "*** ") || !errors.Is(e.Err, errMBox) {
+			t.Errorf("%s: Unexpected last event %+v", op, e)
+		}
+	}
+}
+
+func TestAdaptMBoxHandler(t *testing.T) {
+	if AdaptMBoxHandler(nil) != nil {
+		t.Errorf("Expected nil handler")
+	}
+
+	s := NewSession("LA5NTA", "N0CALL", "JO39EQ", newMemMBox())
+	if s.partials == nil || s.aborts == nil {
+		t.Errorf("Optional interfaces of the adapted handler not used")
+	}
+}
@@ -5,34 +5,45 @@
 package fbb
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"regexp"
 	"strings"
 )
 
+// ErrNoFB2 is returned when the remote supports neither B2F nor any of the legacy FBB forwarding protocols.
 var ErrNoFB2 = errors.New("Remote does not support B2 Forwarding Protocol")
 
+// ErrSecureLoginFailed is returned (and sent to the remote) when the remote's secure login response is not valid.
+var ErrSecureLoginFailed = errors.New("Secure login failed - account password does not match")
+
 // IsLoginFailure returns a boolean indicating whether the error is known to
 // report that the secure login failed.
+//
+// This is true for any error where errors.Is(err, ErrSecureLoginFailed), as well as untyped errors
+// with a message reporting a failed secure login.
 func IsLoginFailure(err error) bool {
 	if err == nil {
 		return false
 	}
-	errStr := strings.ToLower(err.Error())
-	return strings.Contains(errStr, "secure login failed")
+	return errors.Is(err, ErrSecureLoginFailed) || isLoginFailureText(err.Error())
 }
 
 func (s *Session) handshake(rw io.ReadWriter) error {
 	if s.master {
 		// Send MOTD lines
 		for _, line := range s.motd {
+			s.event(Event{Type: EventHandshake, Outbound: true, Line: line})
 			fmt.Fprintf(rw, "%s\r", line)
 		}
 
+		// Challenge the remote if we are able to verify the response
+		if s.passwordLookup != nil {
+			s.secureChallenge = newSecureChallenge()
+		}
+
 		if err := s.sendHandshake(rw, ""); err != nil {
 			return err
 		}
@@ -45,41 +56,80 @@ func (s *Session) handshake(rw io.ReadWriter) error {
 
 	// Did we get SID codes?
 	if hs.SID == "" {
-		return errors.New("No sid in handshake")
+		return protocolError(PhaseHandshake, "", "No sid in handshake")
+	}
+
+	// Do we support the remote's SID codes?
+	if s.proto, err = negotiateProtocol(hs.SID); err != nil {
+		return err
+	} else if s.proto != protoB2F {
+		s.log.Printf("Remote does not support B2F, falling back to FBB protocol (%s)", s.proto.sid())
 	}
 
 	s.remoteSID = hs.SID
 	s.remoteFW = hs.FW
+	s.remote = newRemoteInfo(hs)
 
-	var secureResp string
-	if hs.SecureChallenge != "" {
-		if s.secureLoginHandleFunc == nil {
-			return errors.New("Got secure login challenge, please register a SecureLoginHandleFunc.")
-		}
-
-		password, err := s.secureLoginHandleFunc()
-		if err != nil {
-			return err
-		}
-
-		secureResp = secureLoginResponse(hs.SecureChallenge, password)
+	if hs.SecureChallenge != "" && s.secureLoginHandleFunc == nil {
+		return errors.New("Got secure login challenge, please register a SecureLoginHandleFunc.")
 	}
 
 	if !s.master {
-		return s.sendHandshake(rw, secureResp)
+		return s.sendHandshake(rw, hs.SecureChallenge)
+	} else if s.secureChallenge != "" {
+		return s.verifySecureLogin(hs)
 	} else {
 		return nil
 	}
 }
 
+// verifySecureLogin verifies the remote's secure login responses to our challenge.
+//
+// The ;PR response must be valid for the remote's primary address (the first forwarder address, or
+// targetcall if none), and every auxiliary forwarder address must carry a valid individual response.
+func (s *Session) verifySecureLogin(hs handshakeData) error {
+	primary := AddressFromString(s.targetcall)
+	if len(hs.FW) > 0 {
+		primary = hs.FW[0]
+	}
+
+	if !s.validSecureResponse(primary, hs.SecureResponse) {
+		s.log.Printf("Secure login failed for %s", primary)
+		return &LoginError{Addr: primary, Err: ErrSecureLoginFailed}
+	}
+
+	for i := 1; i < len(hs.FW); i++ {
+		if !s.validSecureResponse(hs.FW[i], hs.FWResponses[i]) {
+			s.log.Printf("Secure login failed for auxiliary address %s", hs.FW[i])
+			return &LoginError{Addr: hs.FW[i], Err: ErrSecureLoginFailed}
+		}
+	}
+	return nil
+}
+
+func (s *Session) validSecureResponse(addr Address, response string) bool {
+	if response == "" {
+		return false
+	}
+	password, ok := s.passwordLookup.LookupPassword(addr)
+	return ok && secureLoginResponse(s.secureChallenge, password) == response
+}
+
 type handshakeData struct {
 	SID             sid
+	SIDHeader       string // The raw line holding the SID (ie. [WL2K-2.8.4.8-B2FWIHJM$])
+	Callsign        string // From the "; X DE Y (GRID)" line
+	Locator         string // From the "; X DE Y (GRID)" line
+	Banner          []string
+	QTC             int // The number of messages waiting, from the "; X DE Y QTC n" line (-1 if not announced)
 	FW              []Address
+	FWResponses     []string // The secure login response for each FW address (if any)
 	SecureChallenge string
+	SecureResponse  string
 }
 
 func (s *Session) readHandshake() (handshakeData, error) {
-	data := handshakeData{}
+	data := handshakeData{QTC: -1}
 
 	for {
 		bytes, err := s.rd.Peek(1)
@@ -96,6 +146,9 @@ func (s *Session) readHandshake() (handshakeData, error) {
 		if err != nil {
 			return data, err
 		}
+		if !strings.Contains(line, `[`) {
+			s.event(Event{Type: EventHandshake, Line: line})
+		}
 
 		//REVIEW: We should probably be more strict on what to allow here,
 		// to ensure we disconnect early if the remote is not talking the expected
@@ -106,47 +159,82 @@ func (s *Session) readHandshake() (handshakeData, error) {
 			if err != nil {
 				return data, err
 			}
-
-			// Do we support the remote's SID codes?
-			if !data.SID.Has(sFBComp2) { // We require FBB compressed protocol v2 for now
-				return data, ErrNoFB2
-			}
+			data.SIDHeader = line
+			s.event(Event{Type: EventRemoteSID, Line: line, SID: string(data.SID)})
 		case strings.HasPrefix(line, ";FW"): // Forwarders
-			data.FW, err = parseFW(line)
+			data.FW, data.FWResponses, err = splitFW(line)
 			if err != nil {
 				return data, err
 			}
 		case strings.HasPrefix(line, ";PQ"): // Secure password challenge
 			data.SecureChallenge = line[5:]
 
+		case strings.HasPrefix(line, ";PR: "): // Secure password response
+			data.SecureResponse = strings.TrimSpace(line[5:])
+
+		case qtcRe.MatchString(line): // Messages waiting (ie. ; LA5NTA DE N0CALL QTC 3)
+			data.QTC = parseQTCLine(line)
+			s.event(Event{Type: EventQTC, Line: line, QTC: data.QTC})
+		case identRe.MatchString(line): // Identification (ie. ; LA5NTA DE N0CALL (JO39EQ)>)
+			data.Callsign, data.Locator = parseIdentLine(line)
+			if strings.HasSuffix(line, ">") {
+				return data, nil // Prompt
+			}
 		case strings.HasSuffix(line, ">"): // Prompt
 			return data, nil
 		default:
-			// Ignore
+			data.Banner = append(data.Banner, line) // MOTD or banner
 		}
 	}
 }
 
-func (s *Session) sendHandshake(writer io.Writer, secureResp string) error {
-	w := bufio.NewWriter(writer)
+func (s *Session) sendHandshake(writer io.Writer, secureChallenge string) error {
+	// Compute the secure login responses (one per localFW) before anything is written,
+	// so that we don't send a partial handshake if a password is unavailable.
+	var secureResps []string
+	if secureChallenge != "" {
+		secureResps = make([]string, len(s.localFW))
+		for i, addr := range s.localFW {
+			password, err := s.secureLoginHandleFunc(addr)
+			if err != nil {
+				return err
+			}
+			if password != "" {
+				secureResps[i] = secureLoginResponse(secureChallenge, password)
+			}
+		}
+	}
 
-	// Request messages on behalf of every localFW
-	fmt.Fprintf(w, ";FW:")
-	for i, addr := range s.localFW {
-		// Include passwordhash for auxiliary calls (required by WL2K-4.x or later)
-		if secureResp != "" && i > 0 {
-			//TODO: Add support for individual passwords
-			fmt.Fprintf(w, " %s|%s", addr.Addr, secureResp)
-		} else {
-			fmt.Fprintf(w, " %s", addr.Addr)
+	// The handshake is buffered and written at once
+	w := new(bytes.Buffer)
+
+	// Request messages on behalf of every localFW (B2F only)
+	if s.proto == protoB2F {
+		fmt.Fprintf(w, ";FW:")
+		for i, addr := range s.localFW {
+			// Include passwordhash for auxiliary calls (required by WL2K-4.x or later)
+			if i > 0 && secureResps != nil && secureResps[i] != "" {
+				fmt.Fprintf(w, " %s|%s", addr.Addr, secureResps[i])
+			} else {
+				fmt.Fprintf(w, " %s", addr.Addr)
+			}
 		}
+		fmt.Fprintf(w, "\r")
 	}
-	fmt.Fprintf(w, "\r")
 
-	writeSID(w, s.ua.Name, s.ua.Version)
+	// When master, the handshake is sent before the protocol is negotiated (s.proto is B2F).
+	writeSID(w, s.ua.Name, s.ua.Version, s.localSID())
+
+	if s.master && s.secureChallenge != "" {
+		writeSecureLoginChallenge(w, s.secureChallenge)
+	}
 
-	if secureResp != "" {
-		writeSecureLoginResponse(w, secureResp)
+	if secureResps != nil && secureResps[0] != "" {
+		writeSecureLoginResponse(w, secureResps[0])
+	}
+
+	if err := s.writeQTC(w); err != nil {
+		return err
 	}
 
 	fmt.Fprintf(w, "; %s DE %s (%s)", s.targetcall, s.mycall, s.locator)
@@ -156,23 +244,42 @@ func (s *Session) sendHandshake(writer io.Writer, secureResp string) error {
 		fmt.Fprintf(w, "\r")
 	}
 
-	return w.Flush()
+	for _, line := range strings.SplitAfter(w.String(), "\r") {
+		if line != "" {
+			s.event(Event{Type: EventHandshake, Outbound: true, Line: strings.TrimSuffix(line, "\r")})
+		}
+	}
+
+	_, err := writer.Write(w.Bytes())
+	return err
 }
 
 func parseFW(line string) ([]Address, error) {
+	addrs, _, err := splitFW(line)
+	return addrs, err
+}
+
+// splitFW parses the forward line, returning the addresses and their (optional) secure login response.
+func splitFW(line string) ([]Address, []string, error) {
 	if !strings.HasPrefix(line, ";FW: ") {
-		return nil, errors.New("Malformed forward line")
+		return nil, nil, protocolError(PhaseHandshake, line, "Malformed forward line")
 	}
 
 	fws := strings.Split(line[5:], " ")
 	addrs := make([]Address, 0, len(fws))
+	resps := make([]string, 0, len(fws))
 
-	for _, str := range strings.Split(line[5:], " ") {
-		str = strings.Split(str, "|")[0] // Strip password hashes (unsupported)
-		addrs = append(addrs, AddressFromString(str))
+	for _, str := range fws {
+		parts := strings.SplitN(str, "|", 2)
+		addrs = append(addrs, AddressFromString(parts[0]))
+		if len(parts) == 2 {
+			resps = append(resps, parts[1])
+		} else {
+			resps = append(resps, "")
+		}
 	}
 
-	return addrs, nil
+	return addrs, resps, nil
 }
 
 type sid string
@@ -189,25 +296,32 @@ const (
 	sHL         = "H"  // Hierarchical Location designators supported
 	sMID        = "M"  // Message identifier supported
 	sCompBatchF = "X"  // Compressed batch forwarding supported
-	sI          = "I"  // "Identify"? Palink-unix sends ";target de mycall QTC n" when remote has this
+	sI          = "I"  // Message-waiting indication. Paclink-unix sends ";target de mycall QTC n" when remote has this (see SetQTC)
 	sBID        = "$"  // BID supported (must be last character in SID)
 
-	sGzip = "G" // Gzip compressed messages supported (GZIP_EXPERIMENT)
+	sGzip = "G" // Gzip compressed messages supported (see GzipCodec)
 )
 
-func gzipExperimentEnabled() bool { return os.Getenv("GZIP_EXPERIMENT") == "1" }
-
-func writeSID(w io.Writer, appName, appVersion string) error {
-	sid := localSID
-
-	if gzipExperimentEnabled() {
-		sid = sid[0:len(sid)-1] + sGzip + sid[len(sid)-1:]
+// localSID returns the SID codes we advertise, including the flags of the enabled codecs (B2F only)
+// acknowledgements (see AckHandler), batch forwarding (see SetBatchLimit) and QTC (see SetQTC).
+func (s *Session) localSID() string {
+	sid := s.ackSID() + s.proto.sid()
+	if s.proto != protoB2F {
+		return sid
 	}
+	return sid[:len(sid)-1] + s.codecSID() + s.batchSID() + s.qtcSID() + sBID // BID must be last
+}
 
+func writeSID(w io.Writer, appName, appVersion, sid string) error {
 	_, err := fmt.Fprintf(w, "[%s-%s-%s]\r", appName, appVersion, sid)
 	return err
 }
 
+func writeSecureLoginChallenge(w io.Writer, challenge string) error {
+	_, err := fmt.Fprintf(w, ";PQ: %s\r", challenge)
+	return err
+}
+
 func writeSecureLoginResponse(w io.Writer, response string) error {
 	_, err := fmt.Fprintf(w, ";PR: %s\r", response)
 	return err
@@ -216,7 +330,7 @@ func writeSecureLoginResponse(w io.Writer, response string) error {
 func parseSID(str string) (sid, error) {
 	code := regexp.MustCompile(`\[.*-(.*)\]`).FindStringSubmatch(str)
 	if len(code) != 2 {
-		return sid(""), errors.New(`Bad SID line: ` + str)
+		return sid(""), protocolError(PhaseHandshake, str, "Bad SID line: %s", str)
 	}
 
 	return sid(
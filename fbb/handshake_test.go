@@ -5,9 +5,12 @@
 package fbb
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -42,3 +45,44 @@ func TestIsLoginFailure(t *testing.T) {
 		}
 	}
 }
+
+func TestSecureLoginAuxiliaryAddresses(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	passwords := map[string]string{
+		"LA5NTA":   "FOOBAR",
+		"LA5NTA-1": "FooBar",
+		"EMCOMM":   "", // No password, should be requested without response.
+	}
+
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+		s.SetLogger(discardLogger)
+		s.AddAuxiliaryAddress(AddressFromString("LA5NTA-1"), AddressFromString("EMCOMM"))
+		s.SetSecureLoginHandleFunc(func(addr Address) (string, error) {
+			return passwords[addr.Addr], nil
+		})
+		s.Exchange(client)
+	}()
+
+	fmt.Fprint(srv, "[WL2K-4.0-B2FWIHJM$]\r")
+	fmt.Fprint(srv, ";PQ: 23753528\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	expectLines := []string{
+		";FW: LA5NTA LA5NTA-1|95074758 EMCOMM\r",
+		"[wl2kgo-0.1a-B2FHM$]\r",
+		";PR: 72768415\r",
+		"; LA1B-10 DE LA5NTA (JO39EQ)\r",
+	}
+
+	rd := bufio.NewReader(srv)
+	for i, expected := range expectLines {
+		line, _ := rd.ReadString('\r')
+		if line != expected {
+			line, expected = strings.TrimSpace(line), strings.TrimSpace(expected)
+			t.Fatalf("Unexpected line [%d]: Got '%s', expected '%s'.", i, line, expected)
+		}
+	}
+}
@@ -35,6 +35,13 @@ const (
 	HEADER_BODY    = `Body`
 	HEADER_FILE    = `File`
 
+	// The @BBS (routing) field of messages received with the legacy FBB protocols.
+	HEADER_AT = `At`
+
+	// Threading of replies and forwarded messages (see Message.Reply and Message.Forward).
+	HEADER_IN_REPLY_TO = `In-Reply-To` // The MID of the message replied to
+	HEADER_REFERENCES  = `References`  // Space separated MIDs of the preceding messages in the thread
+
 	// These headers are stripped by the winlink system, but let's
 	// include it anyway... just in case the winlink team one day
 	// starts taking encoding seriously.
@@ -6,7 +6,6 @@ package fbb
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"strings"
 )
@@ -50,6 +49,7 @@ func (s *Session) nextLineRemoteErr(parseErr bool) (string, error) {
 	s.pLog.Println(line)
 
 	if err := errLine(line); parseErr && err != nil {
+		s.event(Event{Type: EventError, Line: line, Err: err})
 		return "", err
 	} else {
 		return line, nil
@@ -60,6 +60,9 @@ func (s *Session) nextLine() (string, error) {
 	return s.nextLineRemoteErr(true)
 }
 
+// errLine returns the error reported by the given error line as *RemoteError, or nil if it's not an error line.
+//
+// Reported login failures are returned as *LoginError wrapping the *RemoteError.
 func errLine(str string) error {
 	if len(str) == 0 || str[0] != '*' {
 		return nil
@@ -70,7 +73,11 @@ func errLine(str string) error {
 		return nil
 	}
 
-	return fmt.Errorf(strings.TrimSpace(str[idx+1:]))
+	err := &RemoteError{Line: str}
+	if isLoginFailureText(err.Message()) {
+		return &LoginError{Err: err}
+	}
+	return err
 }
 
 func cleanString(str string) string {
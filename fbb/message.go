@@ -11,8 +11,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/textproto"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +28,9 @@ type ValidationError struct {
 
 func (e ValidationError) Error() string { return e.Err }
 
+// MaxSubjectLength is the maximum length of the (encoded) Subject header field.
+const MaxSubjectLength = 128
+
 // Representation of a receiver/sender address.
 type Address struct {
 	Proto string
@@ -33,10 +38,18 @@ type Address struct {
 }
 
 // File represents an attachment.
+//
+// The content is either held in memory (see NewFile), or read when needed from an io.ReaderAt
+// (see NewFileReaderAt) or a file on disk (see NewFileFromPath).
 type File struct {
 	data []byte
 	name string
 	err  error
+
+	r       io.ReaderAt // Backing reader, if not held in memory
+	path    string      // Backing file, if not held in memory
+	spooled bool        // The backing file was created by ReadFromSpool
+	size    int
 }
 
 // Message represent the Winlink 2000 Message Structure as defined in http://winlink.org/B2F.
@@ -49,6 +62,8 @@ type Message struct {
 
 	body  []byte
 	files []*File
+
+	priority Priority // Local metadata, see SetPriority
 }
 
 type MsgType string
@@ -60,6 +75,7 @@ const (
 	PositionReport         = "Position Report"
 	Option                 = "Option"
 	System                 = "System"
+	Bulletin               = "Bulletin" // See NewBulletin
 )
 
 // Slice of date layouts that should be tried when parsing the Date header.
@@ -134,7 +150,7 @@ func (m *Message) Validate() error {
 		// This is not documented, but the CMS writes the proposal title if this is empty
 		// (which I guess is a compatibility hack on their end).
 		return ValidationError{HEADER_SUBJECT, "Empty subject"}
-	case len(m.Header.Get(HEADER_SUBJECT)) > 128:
+	case len(m.Header.Get(HEADER_SUBJECT)) > MaxSubjectLength:
 		return ValidationError{HEADER_SUBJECT, "Subject too long"}
 	}
 
@@ -156,11 +172,11 @@ func (m *Message) MID() string { return m.Header.Get(HEADER_MID) }
 // SetSubject sets this message's subject field.
 //
 // The Winlink Message Format only allow ASCII characters. Words containing non-ASCII characters are Q-encoded with DefaultCharset (as defined by RFC 2047).
-func (m *Message) SetSubject(str string) {
-	encoded, _ := toCharset(DefaultCharset, str)
-	encoded = mime.QEncoding.Encode(DefaultCharset, encoded)
+func (m *Message) SetSubject(str string) { m.Header.Set(HEADER_SUBJECT, encodeSubject(str)) }
 
-	m.Header.Set(HEADER_SUBJECT, encoded)
+func encodeSubject(str string) string {
+	encoded, _ := toCharset(DefaultCharset, str)
+	return mime.QEncoding.Encode(DefaultCharset, encoded)
 }
 
 // Subject returns this message's subject header decoded using WordDecoder.
@@ -292,7 +308,18 @@ func (m *Message) Cc() (cc []Address) {
 // Implements ReaderFrom for Message.
 //
 // Reads the given io.Reader and fills in values fetched from the stream.
-func (m *Message) ReadFrom(r io.Reader) error {
+func (m *Message) ReadFrom(r io.Reader) error { return m.readFrom(r, "", -1) }
+
+// ReadFromSpool is like ReadFrom, but attachments larger than threshold bytes are written to new
+// files in dir (see ioutil.TempFile) instead of being held in memory.
+//
+// The spooled attachments are backed by these files (see File.Path). Removing them when the
+// message is no longer needed is the caller's responsibility.
+func (m *Message) ReadFromSpool(r io.Reader, dir string, threshold int) error {
+	return m.readFrom(r, dir, threshold)
+}
+
+func (m *Message) readFrom(r io.Reader, spoolDir string, spoolThreshold int) error {
 	reader := bufio.NewReader(r)
 
 	if h, err := textproto.NewReader(reader).ReadMIMEHeader(); err != nil {
@@ -326,7 +353,12 @@ func (m *Message) ReadFrom(r io.Reader) error {
 		// The name part of this header may be utf8 encoded by Winlink Express. Use WordDecoder to be safe.
 		file.name, _ = dec.DecodeHeader(slice[1])
 
-		file.data, err = readSection(reader, size)
+		if spoolThreshold >= 0 && size > spoolThreshold {
+			file.path, err = spoolSection(reader, spoolDir, size)
+			file.size, file.spooled = size, file.path != ""
+		} else {
+			file.data, err = readSection(reader, size)
+		}
 		if err != nil {
 			file.err = err
 		}
@@ -337,38 +369,67 @@ func (m *Message) ReadFrom(r io.Reader) error {
 		_, err = ParseDate(m.Header.Get(HEADER_DATE))
 	}
 
+	if err != nil {
+		m.removeSpooled()
+	}
 	return err
 }
 
+// removeSpooled removes the files of attachments spooled to disk by ReadFromSpool.
+func (m *Message) removeSpooled() {
+	for _, f := range m.files {
+		if !f.spooled {
+			continue
+		}
+		os.Remove(f.path)
+		f.path, f.spooled, f.err = "", false, errors.New("Spooled file removed")
+	}
+}
+
 func readSection(reader *bufio.Reader, readN int) ([]byte, error) {
-	buf := make([]byte, readN)
+	var buf bytes.Buffer
+	buf.Grow(readN)
+	err := copySection(&buf, reader, readN)
+	return buf.Bytes(), err
+}
 
-	var err error
-	n := 0
-	for n < readN {
-		m, err := reader.Read(buf[n:])
-		if err != nil {
-			break
-		}
-		n += m
+// spoolSection writes the section to a new file in dir, returning the path of the file.
+func spoolSection(reader *bufio.Reader, dir string, readN int) (string, error) {
+	f, err := ioutil.TempFile(dir, "wl2k-file-")
+	if err != nil {
+		return "", err
 	}
 
+	err = copySection(f, reader, readN)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return buf, err
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// copySection copies a section of readN bytes to w, and consumes the line break ending the section.
+func copySection(w io.Writer, reader *bufio.Reader, readN int) error {
+	switch _, err := io.CopyN(w, reader, int64(readN)); {
+	case err == io.EOF:
+		return io.ErrUnexpectedEOF
+	case err != nil:
+		return err
 	}
 
 	end, err := reader.ReadString('\n')
 	switch {
-	case n != readN:
-		return buf, io.ErrUnexpectedEOF
 	case err == io.EOF:
 		// That's ok
 	case err != nil:
-		return buf, err
+		return err
 	case end != "\r\n":
-		return buf, errors.New("Unexpected end of section")
+		return errors.New("Unexpected end of section")
 	}
-	return buf, nil
+	return nil
 }
 
 // Returns true if the given Address is the only receiver of this Message.
@@ -383,13 +444,21 @@ func (m *Message) IsOnlyReceiver(addr Address) bool {
 // Method for generating a proposal of the message.
 //
 // An error is returned if the Validate method fails.
+//
+// AsciiProposal and BasicProposal (legacy FBB) requires a message with a single call sign
+// recipient and no attachments. AsciiProposals are compressed as FBB compressed protocol v1.
 func (m *Message) Proposal(code PropCode) (*Proposal, error) {
-	data, err := m.Bytes()
+	if code == AsciiProposal || code == BasicProposal {
+		return m.fbbProposal(code, false)
+	}
+
+	// The message is compressed as it's written, so that the raw message is never held in memory
+	prop, err := newProposal(m.MID(), m.Subject(), code, m.Write)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewProposal(m.MID(), m.Subject(), code, data), m.Validate()
+	return prop, m.Validate()
 }
 
 // Receivers returns a slice of all receivers of this message.
@@ -450,7 +519,9 @@ func (m *Message) Write(w io.Writer) (err error) {
 
 	// Files (the order must be the same as they appear in the header)
 	for _, f := range m.Files() {
-		writer.Write(f.data)
+		if err := f.writeTo(writer); err != nil {
+			return err
+		}
 		writer.WriteString("\r\n") // end of file
 	}
 
@@ -498,15 +569,75 @@ func (f *File) MarshalJSON() ([]byte, error) {
 func (f *File) Name() string { return f.name }
 
 // Size returns the attachments's size in bytes.
-func (f *File) Size() int { return len(f.data) }
+func (f *File) Size() int {
+	if f.data == nil {
+		return f.size
+	}
+	return len(f.data)
+}
+
+// Path returns the path of the file backing the attachment, or an empty string if the
+// attachment is not backed by a file (see NewFileFromPath and Message.ReadFromSpool).
+func (f *File) Path() string { return f.path }
 
 // Data returns a copy of the attachment content.
+//
+// The content of attachments not held in memory is read on each call, and nil is returned
+// if it can't be read. Use Open to read large attachments.
 func (f *File) Data() []byte {
+	if f.data == nil && (f.r != nil || f.path != "") {
+		rc, err := f.Open()
+		if err != nil {
+			return nil
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+
 	cpy := make([]byte, len(f.data))
 	copy(cpy, f.data)
 	return cpy
 }
 
+// Open returns a reader of the attachment content.
+//
+// It is the caller's responsibility to call Close on the ReadCloser when done.
+func (f *File) Open() (io.ReadCloser, error) {
+	switch {
+	case f.r != nil:
+		return ioutil.NopCloser(io.NewSectionReader(f.r, 0, int64(f.size))), nil
+	case f.path != "":
+		return os.Open(f.path)
+	default:
+		return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+	}
+}
+
+// writeTo writes the attachment content to w.
+//
+// An error is returned if the content does not match the size of the attachment, as that
+// would corrupt the message.
+func (f *File) writeTo(w io.Writer) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("Unable to read attachment %s: %w", f.Name(), err)
+	}
+	defer rc.Close()
+
+	n, err := io.Copy(w, rc)
+	switch {
+	case err != nil:
+		return fmt.Errorf("Unable to read attachment %s: %w", f.Name(), err)
+	case n != int64(f.Size()):
+		return fmt.Errorf("Attachment %s changed size (expected %d bytes, got %d)", f.Name(), f.Size(), n)
+	}
+	return nil
+}
+
 // Create a new file (attachment) with the given name and data.
 //
 // A B2F file must have an associated name. If the name is empty, NewFile will panic.
@@ -520,6 +651,47 @@ func NewFile(name string, data []byte) *File {
 	}
 }
 
+// NewFileReaderAt creates a new file (attachment) with the given name, whose content is the
+// first size bytes of r.
+//
+// The content is read from r when needed (e.g. when the message is written), and is not held
+// in memory. If the name is empty, NewFileReaderAt will panic.
+func NewFileReaderAt(name string, r io.ReaderAt, size int64) *File {
+	if name == "" {
+		panic("Empty filename is not allowed")
+	}
+	return &File{
+		name: name,
+		r:    r,
+		size: int(size),
+	}
+}
+
+// NewFileFromPath creates a new file (attachment) with the given name, backed by the file at path.
+//
+// The content is read from path when needed (e.g. when the message is written), and is not held
+// in memory. The file must not be changed or removed while the attachment is in use. If the name
+// is empty, NewFileFromPath will panic.
+func NewFileFromPath(name, path string) (*File, error) {
+	if name == "" {
+		panic("Empty filename is not allowed")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	return &File{
+		name: name,
+		path: path,
+		size: int(info.Size()),
+	}, nil
+}
+
 // Textual representation of Address.
 func (a Address) String() string {
 	if a.Proto == "" {
@@ -6,6 +6,10 @@ package fbb
 
 import (
 	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -84,6 +88,183 @@ func TestDecodeNonASCIIFileNames(t *testing.T) {
 	}
 }
 
+func TestBackedFiles(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	dir, err := ioutil.TempDir("", "fbb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "content.bin")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pathFile, err := NewFileFromPath("path.bin", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []*File{
+		NewFile("memory.bin", content),
+		NewFileReaderAt("readerat.bin", bytes.NewReader(content), int64(len(content))),
+		pathFile,
+	}
+
+	msg := testMessage("N0CALL", "LA5NTA", 10)
+	for _, f := range files {
+		if f.Size() != len(content) || !bytes.Equal(f.Data(), content) {
+			t.Errorf("Unexpected content of %s (%d bytes)", f.Name(), f.Size())
+		}
+		msg.AddFile(f)
+	}
+
+	var buf bytes.Buffer
+	if err := msg.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read with the two largest files spooled to disk
+	got := new(Message)
+	if err := got.ReadFromSpool(bytes.NewReader(buf.Bytes()), dir, len(content)-1); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files()) != len(files) {
+		t.Fatalf("Expected %d files, got %d", len(files), len(got.Files()))
+	}
+	for _, f := range got.Files() {
+		if f.Path() == "" || filepath.Dir(f.Path()) != dir {
+			t.Errorf("Expected %s to be spooled to %s, got %q", f.Name(), dir, f.Path())
+		}
+		if !bytes.Equal(f.Data(), content) {
+			t.Errorf("Unexpected content of spooled %s", f.Name())
+		}
+	}
+
+	// A changed backing file should not corrupt the message
+	if err := ioutil.WriteFile(path, content[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Write(ioutil.Discard); err == nil {
+		t.Errorf("Expected error writing message with truncated attachment")
+	}
+}
+
+// spoolMBox is a memMBox recording the attachments of inbound messages, as seen by ProcessInbound.
+type spoolMBox struct {
+	*memMBox
+	paths []string
+	data  [][]byte
+}
+
+func (m *spoolMBox) ProcessInbound(msgs ...*Message) error {
+	for _, msg := range msgs {
+		for _, f := range msg.Files() {
+			m.paths, m.data = append(m.paths, f.Path()), append(m.data, f.Data())
+		}
+	}
+	return m.memMBox.ProcessInbound(msgs...)
+}
+
+func TestSessionSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fbb-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	small, large := []byte("small"), bytes.Repeat([]byte("large "), 1000)
+	msg := testMessage("N0CALL", "LA5NTA", 10)
+	msg.AddFile(NewFile("small.txt", small))
+	msg.AddFile(NewFile("large.txt", large))
+
+	mbox := &spoolMBox{memMBox: newMemMBox()}
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", mbox)
+	master.SetLogger(discardLogger)
+	master.SetSpool(dir, 1024)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(msg))
+	client.SetLogger(discardLogger)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	switch {
+	case len(mbox.paths) != 2:
+		t.Fatalf("Expected 2 attachments, got %d", len(mbox.paths))
+	case mbox.paths[0] != "" || !bytes.Equal(mbox.data[0], small):
+		t.Errorf("Unexpected small attachment (path %q)", mbox.paths[0])
+	case filepath.Dir(mbox.paths[1]) != dir || !bytes.Equal(mbox.data[1], large):
+		t.Errorf("Expected large attachment spooled to %s, got %q", dir, mbox.paths[1])
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Spooled files not removed after ProcessInbound: %v", files)
+	}
+}
+
+func TestSpoolRemovedOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fbb-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	msg := testMessage("N0CALL", "LA5NTA", 10)
+	msg.AddFile(NewFile("first.bin", bytes.Repeat([]byte{1}, 100)))
+	msg.AddFile(NewFile("second.bin", bytes.Repeat([]byte{2}, 100)))
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncated in the middle of the second attachment
+	if err := new(Message).ReadFromSpool(bytes.NewReader(data[:len(data)-50]), dir, 10); err == nil {
+		t.Errorf("Expected error reading truncated message")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Spooled files not removed on error: %v", files)
+	}
+}
+
+func TestProposalStreaming(t *testing.T) {
+	content := bytes.Repeat([]byte("streaming "), 500)
+	msg := testMessage("N0CALL", "LA5NTA", 100)
+	msg.AddFile(NewFileReaderAt("stream.txt", bytes.NewReader(content), int64(len(content))))
+
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, code := range []PropCode{Wl2kProposal, GzipProposal} {
+		prop, err := msg.Proposal(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := NewProposal(msg.MID(), msg.Subject(), code, data); prop.size != expect.size || !bytes.Equal(prop.compressedData, expect.compressedData) {
+			t.Errorf("%c: Streamed proposal differs from NewProposal", code)
+		}
+		if prop, err := NewProposalReader(msg.MID(), msg.Subject(), code, bytes.NewReader(data)); err != nil || prop.size != len(data) {
+			t.Errorf("%c: Unexpected NewProposalReader result: %v", code, err)
+		}
+
+		got, err := prop.Message()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.MID() != msg.MID() || len(got.Files()) != 1 || !bytes.Equal(got.Files()[0].Data(), content) {
+			t.Errorf("%c: Unexpected message decompressed from proposal", code)
+		}
+
+		// Corrupt data is detected, although the message is parsed while decompressing
+		prop.compressedData[len(prop.compressedData)/2] ^= 0xFF
+		if _, err := prop.Message(); err == nil {
+			t.Errorf("%c: Expected error for corrupt proposal", code)
+		}
+	}
+}
+
 func IsIllegalHeader(str string) bool {
 	for _, c := range str {
 		if !IsGraphicASCII(c) {
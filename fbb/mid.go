@@ -7,7 +7,9 @@ package fbb
 import (
 	"crypto/md5"
 	"encoding/base32"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -19,6 +21,28 @@ func GenerateMid(callsign string) string {
 	return base32.StdEncoding.EncodeToString(sum[0:])[0:MaxMIDLength]
 }
 
+// ValidateMID returns an error if MID is empty, longer than MaxMIDLength or contains
+// path separators (/ or \) or "..".
+//
+// The MID (or BID) of a proposal is given by the remote, and is commonly used in file names.
+func ValidateMID(MID string) error {
+	switch {
+	case MID == "":
+		return errors.New("Empty MID")
+	case len(MID) > MaxMIDLength:
+		return errors.New("MID too long")
+	case strings.ContainsAny(MID, `/\`) || strings.Contains(MID, ".."):
+		return errors.New("Illegal characters in MID")
+	}
+	return nil
+}
+
+// derivedMID returns a MID derived from the given string, e.g. for messages generated from other messages.
+func derivedMID(str string) string {
+	sum := md5.Sum([]byte(str))
+	return base32.StdEncoding.EncodeToString(sum[0:])[0:MaxMIDLength]
+}
+
 func midPayload(callsign string, t time.Time) []byte {
 	return []byte(fmt.Sprintf("%s-%s", time.Now(), callsign))
 }
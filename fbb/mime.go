@@ -0,0 +1,394 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// This file implements conversion between the Winlink Message Structure and RFC 5322 (MIME) email.
+
+// The domain of Winlink addresses (e.g. N0CALL@winlink.org) and MIDs in the Message-ID header.
+const winlinkDomain = "winlink.org"
+
+// ToMIME writes the message to w as an RFC 5322 email.
+//
+// The body is written as a text/plain part in the message's charset (see Charset), and each
+// attachment as a base64 encoded part of a multipart/mixed email. Winlink addresses (call signs)
+// are given the winlink.org domain, and the MID is kept in the Message-ID header as <MID@winlink.org>
+// (as are the MIDs of the In-Reply-To and References headers).
+func (m *Message) ToMIME(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("MIME-Version", "1.0")
+	h.Set("Message-ID", messageID(m.MID()))
+	if date := m.Date(); !date.IsZero() {
+		h.Set("Date", date.Format(time.RFC1123Z))
+	}
+	if from := m.From(); !from.IsZero() {
+		h.Set("From", mimeAddressList([]Address{from}))
+	}
+	if to := m.To(); len(to) > 0 {
+		h.Set("To", mimeAddressList(to))
+	}
+	if cc := m.Cc(); len(cc) > 0 {
+		h.Set("Cc", mimeAddressList(cc))
+	}
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject()))
+	if mid := m.Header.Get(HEADER_IN_REPLY_TO); mid != "" {
+		h.Set("In-Reply-To", messageID(mid))
+	}
+	if refs := strings.Fields(m.Header.Get(HEADER_REFERENCES)); len(refs) > 0 {
+		for i, mid := range refs {
+			refs[i] = messageID(mid)
+		}
+		h.Set("References", strings.Join(refs, " "))
+	}
+
+	if len(m.Files()) == 0 {
+		for k, v := range m.textPartHeader() {
+			h[k] = v
+		}
+		writeMIMEHeader(writer, h)
+		if err := writeQuotedPrintable(writer, m.body); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	mw := multipart.NewWriter(writer)
+	h.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	writeMIMEHeader(writer, h)
+
+	part, err := mw.CreatePart(m.textPartHeader())
+	if err != nil {
+		return err
+	}
+	if err := writeQuotedPrintable(part, m.body); err != nil {
+		return err
+	}
+
+	for _, f := range m.Files() {
+		part, err := mw.CreatePart(filePartHeader(f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := writeBase64(part, f); err != nil {
+			return err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// FromMIME reads an RFC 5322 email from r, and returns it as a Winlink message.
+//
+// The first text/plain part is used as body, keeping its charset, and parts with a file name (or
+// attachment disposition) are added as attachments. Other parts (e.g. text/html alternatives) are
+// ignored.
+//
+// The MID is taken from a Message-ID on the form <MID@winlink.org> (see ToMIME). Otherwise the MID
+// is derived from the Message-ID, or generated if the header is missing. In-Reply-To and References
+// are mapped the same way.
+func FromMIME(r io.Reader) (*Message, error) {
+	email, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(email.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid From header: %w", err)
+	}
+
+	msg := NewMessage(Private, from.Address)
+	if msg.From().Proto != "" {
+		msg.Header.Set(HEADER_MBO, msg.From().Proto)
+	}
+	msg.Header.Set(HEADER_MID, midFromMessageID(email.Header.Get("Message-ID"), msg.MID()))
+
+	if date, err := email.Header.Date(); err == nil {
+		msg.SetDate(date)
+	}
+
+	for _, key := range []string{"To", "Cc"} {
+		if email.Header.Get(key) == "" {
+			continue
+		}
+		addrs, err := email.Header.AddressList(key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s header: %w", key, err)
+		}
+		for _, addr := range addrs {
+			msg.Header.Add(key, AddressFromString(addr.Address).String())
+		}
+	}
+
+	subject, _ := new(WordDecoder).DecodeHeader(email.Header.Get("Subject"))
+	msg.SetSubject(subject)
+
+	if id := email.Header.Get("In-Reply-To"); id != "" {
+		msg.Header.Set(HEADER_IN_REPLY_TO, midFromMessageID(id, ""))
+	}
+	if ids := strings.Fields(email.Header.Get("References")); len(ids) > 0 {
+		for i, id := range ids {
+			ids[i] = midFromMessageID(id, "")
+		}
+		msg.Header.Set(HEADER_REFERENCES, strings.Join(ids, " "))
+	}
+
+	var hasBody bool
+	err = readMIMEPart(textproto.MIMEHeader(email.Header), email.Body, func(h textproto.MIMEHeader, data []byte) error {
+		mediaType, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+		switch {
+		case partFileName(h) != "":
+			msg.AddFile(NewFile(partFileName(h), data))
+		case !hasBody && (mediaType == "text/plain" || mediaType == ""):
+			hasBody = true
+			return msg.setBodyBytes(data, params["charset"])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasBody {
+		return msg, msg.SetBody("")
+	}
+	return msg, nil
+}
+
+// setBodyBytes sets the body to the given data encoded with charset, enforcing CRLF line breaks.
+//
+// The data is kept in the given charset if it is supported, otherwise it's assumed to be DefaultCharset.
+func (m *Message) setBodyBytes(data []byte, charset string) error {
+	if charset == "" {
+		charset = "us-ascii"
+	}
+
+	text, err := BodyFromBytes(data, charset)
+	if err != nil {
+		// Unsupported charset
+		return m.SetBody(string(data))
+	}
+	return m.setBodyCharset(charset, text)
+}
+
+// setBodyCharset sets the body to the given text, encoded with charset (unlike SetBodyWithCharset).
+func (m *Message) setBodyCharset(charset, text string) error {
+	data, err := StringToBody(text, charset)
+	if err != nil {
+		return m.SetBody(text)
+	}
+
+	m.Header.Set(HEADER_CONTENT_TRANSFER_ENCODING, DefaultTransferEncoding)
+	m.Header.Set(HEADER_CONTENT_TYPE, mime.FormatMediaType("text/plain", map[string]string{"charset": charset}))
+	m.body = data
+	m.Header.Set(HEADER_BODY, fmt.Sprintf("%d", len(data)))
+	return nil
+}
+
+// readMIMEPart reads the (possibly multipart) entity with the given header and body, calling fn with
+// the header and decoded data of each leaf part.
+func readMIMEPart(h textproto.MIMEHeader, body io.Reader, fn func(h textproto.MIMEHeader, data []byte) error) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := readMIMEPart(part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return fn(h, data)
+}
+
+// partFileName returns the file name of the part, if it's an attachment.
+func partFileName(h textproto.MIMEHeader) string {
+	disposition, params, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := params["filename"]
+	if name == "" {
+		_, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+		name = params["name"]
+	}
+	switch {
+	case name != "":
+		name, _ = new(WordDecoder).DecodeHeader(name)
+		return filepath.Base(name)
+	case disposition == "attachment":
+		return "attachment"
+	default:
+		return ""
+	}
+}
+
+// midFromMessageID returns the MID given by the Message-ID header (see ToMIME), or fallback if empty.
+func midFromMessageID(messageID, fallback string) string {
+	id := strings.Trim(strings.TrimSpace(messageID), "<>")
+	switch {
+	case id == "":
+		return fallback
+	case strings.HasSuffix(strings.ToLower(id), "@"+winlinkDomain):
+		if mid := id[:len(id)-len(winlinkDomain)-1]; mid != "" && len(mid) <= MaxMIDLength {
+			return mid
+		}
+	}
+	return derivedMID(id)
+}
+
+// messageID returns the Message-ID of the message with the given MID.
+func messageID(mid string) string { return fmt.Sprintf("<%s@%s>", mid, winlinkDomain) }
+
+// mimeAddress returns the email address of the given address. Call signs are given the winlink.org domain.
+func mimeAddress(a Address) string {
+	if a.Proto == "" && !strings.Contains(a.Addr, "@") {
+		return a.Addr + "@" + winlinkDomain
+	}
+	return a.Addr
+}
+
+func mimeAddressList(addrs []Address) string {
+	list := make([]string, len(addrs))
+	for i, a := range addrs {
+		list[i] = (&mail.Address{Address: mimeAddress(a)}).String()
+	}
+	return strings.Join(list, ", ")
+}
+
+// textPartHeader returns the header of the body part.
+func (m *Message) textPartHeader() textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", mime.FormatMediaType("text/plain", map[string]string{"charset": m.Charset()}))
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	return h
+}
+
+// filePartHeader returns the header of an attachment part.
+func filePartHeader(name string) textproto.MIMEHeader {
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	if disposition == "" {
+		// Not representable as a media type parameter, use an encoded-word instead
+		disposition = fmt.Sprintf("attachment; filename=%q", mime.QEncoding.Encode("utf-8", name))
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", disposition)
+	h.Set("Content-Transfer-Encoding", "base64")
+	return h
+}
+
+// writeMIMEHeader writes the header fields in a stable order, followed by the blank line.
+func writeMIMEHeader(w io.Writer, h textproto.MIMEHeader) {
+	for _, key := range []string{"MIME-Version", "Message-ID", "Date", "From", "To", "Cc", "Subject", "In-Reply-To", "References", "Content-Type", "Content-Transfer-Encoding"} {
+		if v := h.Get(key); v != "" {
+			fmt.Fprintf(w, "%s: %s\r\n", key, v)
+		}
+	}
+	fmt.Fprint(w, "\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, data []byte) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(data); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes the base64 encoding of the attachment, in lines of 76 characters.
+//
+// The content is streamed from the attachment (see File.Open).
+func writeBase64(w io.Writer, f *File) error {
+	lw := &lineWriter{w: w, max: 76}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	if err := f.writeTo(enc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return lw.Close()
+}
+
+// lineWriter breaks the written data into CRLF terminated lines of max characters.
+type lineWriter struct {
+	w   io.Writer
+	max int
+	n   int // The length of the current line
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if lw.n == lw.max {
+			if _, err := io.WriteString(lw.w, "\r\n"); err != nil {
+				return written, err
+			}
+			lw.n = 0
+		}
+
+		chunk := p
+		if len(chunk) > lw.max-lw.n {
+			chunk = chunk[:lw.max-lw.n]
+		}
+		n, err := lw.w.Write(chunk)
+		written, lw.n, p = written+n, lw.n+n, p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close terminates the last line.
+func (lw *lineWriter) Close() error {
+	if lw.n == 0 {
+		return nil
+	}
+	lw.n = 0
+	_, err := io.WriteString(lw.w, "\r\n")
+	return err
+}
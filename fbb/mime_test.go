@@ -0,0 +1,205 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMIMERoundTrip(t *testing.T) {
+	date := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+
+	msg := NewMessage(Private, "LA5NTA")
+	msg.Header.Set(HEADER_MID, "MIMETEST1234")
+	msg.SetDate(date)
+	msg.AddTo("N0CALL", "foo@example.com")
+	msg.AddCc("N1CALL")
+	msg.SetSubject("Blåbærsyltetøy")
+	msg.SetBody("Hei på deg!\nVedlagt er to filer.")
+	msg.Header.Set(HEADER_IN_REPLY_TO, "PARENT123456")
+	msg.Header.Set(HEADER_REFERENCES, "ROOT12345678 PARENT123456")
+	msg.AddFile(NewFile("position.txt", []byte("59.4 N, 10.5 E")))
+	msg.AddFile(NewFile("kart ø.bin", bytes.Repeat([]byte{0x00, 0xFF, 0x7F}, 100)))
+
+	var buf bytes.Buffer
+	if err := msg.ToMIME(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Message-ID: <MIMETEST1234@winlink.org>") {
+		t.Errorf("MID not preserved in Message-ID header:\n%s", buf.String())
+	}
+
+	got, err := FromMIME(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case got.MID() != msg.MID():
+		t.Errorf("Unexpected MID %q", got.MID())
+	case got.From() != msg.From():
+		t.Errorf("Unexpected From %s", got.From())
+	case !got.Date().Equal(date):
+		t.Errorf("Unexpected Date %s", got.Date())
+	case got.Subject() != msg.Subject():
+		t.Errorf("Unexpected Subject %q", got.Subject())
+	case got.Charset() != msg.Charset():
+		t.Errorf("Unexpected charset %q", got.Charset())
+	case got.Header.Get(HEADER_IN_REPLY_TO) != "PARENT123456" || got.Header.Get(HEADER_REFERENCES) != "ROOT12345678 PARENT123456":
+		t.Errorf("Threading headers not preserved: %v", got.Header)
+	}
+	if !equalAddresses(got.To(), msg.To()) || !equalAddresses(got.Cc(), msg.Cc()) {
+		t.Errorf("Unexpected receivers: To %v, Cc %v", got.To(), got.Cc())
+	}
+	if body, _ := got.Body(); body != "Hei på deg!\r\nVedlagt er to filer.\r\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+	if got.BodySize() != msg.BodySize() {
+		t.Errorf("Expected body size %d, got %d", msg.BodySize(), got.BodySize())
+	}
+
+	if len(got.Files()) != len(msg.Files()) {
+		t.Fatalf("Expected %d files, got %d", len(msg.Files()), len(got.Files()))
+	}
+	for i, f := range got.Files() {
+		if f.Name() != msg.Files()[i].Name() || !bytes.Equal(f.Data(), msg.Files()[i].Data()) {
+			t.Errorf("Unexpected file %q (%d bytes)", f.Name(), f.Size())
+		}
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("Invalid message: %s", err)
+	}
+}
+
+func TestFromMIME(t *testing.T) {
+	const email = "From: Foo Bar <foo@example.com>\r\n" +
+		"To: N0CALL@winlink.org\r\n" +
+		"Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n" +
+		"Date: Sun, 17 May 2020 14:30:00 +0200\r\n" +
+		"Message-ID: <1234.5678@mail.example.com>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"SGFsbG8gV2VsdCEKR3LDvMOfZQo=\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>Hallo Welt!</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain; name=\"notes.txt\"\r\n" +
+		"Content-Disposition: attachment; filename=\"notes.txt\"\r\n" +
+		"\r\n" +
+		"Some notes\r\n" +
+		"--outer--\r\n"
+
+	msg, err := FromMIME(strings.NewReader(email))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case msg.From().String() != "SMTP:foo@example.com":
+		t.Errorf("Unexpected From %s", msg.From())
+	case len(msg.To()) != 1 || msg.To()[0].String() != "N0CALL":
+		t.Errorf("Unexpected To %v", msg.To())
+	case msg.Subject() != "Grüße":
+		t.Errorf("Unexpected Subject %q", msg.Subject())
+	case !msg.Date().Equal(time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)):
+		t.Errorf("Unexpected Date %s", msg.Date())
+	case msg.Charset() != "utf-8":
+		t.Errorf("Unexpected charset %q", msg.Charset())
+	case len(msg.MID()) != MaxMIDLength || msg.MID() != midFromMessageID("<1234.5678@mail.example.com>", ""):
+		t.Errorf("Expected MID derived from Message-ID, got %q", msg.MID())
+	}
+	if body, _ := msg.Body(); body != "Hallo Welt!\r\nGrüße\r\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+	if len(msg.Files()) != 1 || msg.Files()[0].Name() != "notes.txt" || string(msg.Files()[0].Data()) != "Some notes" {
+		t.Errorf("Unexpected files %v", msg.Files())
+	}
+	if err := msg.Validate(); err != nil {
+		t.Errorf("Invalid message: %s", err)
+	}
+
+	// The UTF-8 body survives a round trip without attachments
+	msg.files = nil
+	var buf bytes.Buffer
+	if err := msg.ToMIME(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromMIME(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := got.Body(); body != "Hallo Welt!\r\nGrüße\r\n" || got.Charset() != "utf-8" || got.MID() != msg.MID() {
+		t.Errorf("Unexpected round trip: %q (%s, %s)", body, got.Charset(), got.MID())
+	}
+}
+
+func TestToMIMEFileError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wl2k-mime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "large.bin")
+	data := bytes.Repeat([]byte{0x00, 0xFF, 0x7F}, 10000)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFileFromPath("large.bin", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := replyTestMessage()
+	msg.AddFile(f)
+
+	// Disk-backed attachments are streamed
+	var buf bytes.Buffer
+	if err := msg.ToMIME(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromMIME(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files()) != 1 || !bytes.Equal(got.Files()[0].Data(), data) {
+		t.Errorf("Attachment not preserved")
+	}
+
+	// An attachment that can't be read is an error, not an empty part
+	os.Remove(path)
+	if err := msg.ToMIME(ioutil.Discard); err == nil {
+		t.Errorf("Expected error for unreadable attachment")
+	}
+}
+
+func equalAddresses(a, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"sort"
+	"strings"
+)
+
+// An OutboundMessage is an outbound message prepared for delivery, as seen by an OrderingPolicy.
+type OutboundMessage struct {
+	*Message
+
+	// The number of bytes to transfer (the compressed size of the message).
+	Size int
+}
+
+// An OrderingPolicy decides the order in which outbound messages are proposed to the remote.
+//
+// Messages that are equal according to the policy are proposed in MID order.
+type OrderingPolicy interface {
+	// Less reports whether message a should be proposed before message b.
+	Less(a, b OutboundMessage) bool
+}
+
+// The OrderingFunc type is an adapter to allow the use of ordinary functions as OrderingPolicy.
+type OrderingFunc func(a, b OutboundMessage) bool
+
+// Less calls f(a, b).
+func (f OrderingFunc) Less(a, b OutboundMessage) bool { return f(a, b) }
+
+// The built-in ordering policies.
+var (
+	// OrderBySize orders messages by size, smallest first as suggested by the Winlink FAQ Q460 (default).
+	OrderBySize OrderingPolicy = OrderingFunc(func(a, b OutboundMessage) bool { return a.Size < b.Size })
+
+	// OrderByDate orders messages by date, oldest first.
+	OrderByDate OrderingPolicy = OrderingFunc(func(a, b OutboundMessage) bool { return a.Date().Before(b.Date()) })
+
+	// OrderByPrecedence orders messages by the precedence given in the subject (see Message.Precedence), highest first.
+	OrderByPrecedence OrderingPolicy = OrderingFunc(func(a, b OutboundMessage) bool { return a.Precedence() > b.Precedence() })
+
+	// OrderByPriority orders messages by their explicit priority (see Message.SetPriority), highest first.
+	OrderByPriority OrderingPolicy = OrderingFunc(func(a, b OutboundMessage) bool { return a.Priority() > b.Priority() })
+)
+
+// OrderingChain returns an OrderingPolicy ordering messages by each of the given policies in turn,
+// until one of them tells the messages apart.
+//
+// Example (flash traffic first, even when it's large):
+//
+//	session.SetOrderingPolicy(fbb.OrderingChain(fbb.OrderByPrecedence, fbb.OrderByPriority, fbb.OrderBySize))
+func OrderingChain(policies ...OrderingPolicy) OrderingPolicy {
+	return OrderingFunc(func(a, b OutboundMessage) bool {
+		for _, p := range policies {
+			switch {
+			case p.Less(a, b):
+				return true
+			case p.Less(b, a):
+				return false
+			}
+		}
+		return false
+	})
+}
+
+// SetOrderingPolicy sets the policy deciding the order of outbound proposals.
+//
+// A nil value resets to the default policy, OrderBySize.
+func (s *Session) SetOrderingPolicy(p OrderingPolicy) { s.ordering = p }
+
+// sortOutbound sorts the outbound proposals (and their messages) according to the session's ordering policy.
+func (s *Session) sortOutbound(msgs []*Message, props []*Proposal) {
+	policy := s.ordering
+	if policy == nil {
+		policy = OrderBySize
+	}
+
+	items := make([]OutboundMessage, len(msgs))
+	for i, msg := range msgs {
+		items[i] = OutboundMessage{Message: msg, Size: props[i].compressedSize}
+	}
+
+	sort.Sort(byPolicy{policy, items, props})
+	for i, item := range items {
+		msgs[i] = item.Message
+	}
+}
+
+type byPolicy struct {
+	policy OrderingPolicy
+	msgs   []OutboundMessage
+	props  []*Proposal
+}
+
+func (s byPolicy) Len() int { return len(s.msgs) }
+func (s byPolicy) Swap(i, j int) {
+	s.msgs[i], s.msgs[j] = s.msgs[j], s.msgs[i]
+	s.props[i], s.props[j] = s.props[j], s.props[i]
+}
+func (s byPolicy) Less(i, j int) bool {
+	switch {
+	case s.policy.Less(s.msgs[i], s.msgs[j]):
+		return true
+	case s.policy.Less(s.msgs[j], s.msgs[i]):
+		return false
+	}
+	return s.props[i].MID() < s.props[j].MID()
+}
+
+// Priority is the explicit priority of an outbound message. Higher values are proposed first by OrderByPriority.
+//
+// The priority is local metadata, and is not transferred with the message.
+type Priority int
+
+// SetPriority sets the explicit priority of the message (see OrderByPriority).
+func (m *Message) SetPriority(p Priority) { m.priority = p }
+
+// Priority returns the explicit priority of the message (see OrderByPriority).
+func (m *Message) Priority() Priority { return m.priority }
+
+// Precedence is the precedence level of a message, as given by a //WL2K subject prefix.
+type Precedence int
+
+// The precedence levels, lowest first.
+const (
+	PrecedenceRoutine   Precedence = iota // R (or no prefix)
+	PrecedencePriority                    // P
+	PrecedenceImmediate                   // O
+	PrecedenceFlash                       // Z
+)
+
+// The subject prefix used to express precedence, e.g. "//WL2K Z/ Subject".
+const precedencePrefix = "//WL2K "
+
+var precedenceCodes = map[byte]Precedence{
+	'R': PrecedenceRoutine,
+	'P': PrecedencePriority,
+	'O': PrecedenceImmediate,
+	'Z': PrecedenceFlash,
+}
+
+// Precedence returns the precedence level of the message, as given by the "//WL2K X/" subject prefix
+// (where X is Z, O, P or R).
+//
+// PrecedenceRoutine is returned if the subject has no valid prefix.
+func (m *Message) Precedence() Precedence {
+	subject := strings.ToUpper(strings.TrimSpace(m.Subject()))
+	if !strings.HasPrefix(subject, precedencePrefix) {
+		return PrecedenceRoutine
+	}
+
+	code := strings.TrimPrefix(subject, precedencePrefix)
+	if len(code) < 2 || code[1] != '/' {
+		return PrecedenceRoutine
+	}
+	return precedenceCodes[code[0]]
+}
+
+func (p Precedence) String() string {
+	switch p {
+	case PrecedencePriority:
+		return "Priority"
+	case PrecedenceImmediate:
+		return "Immediate"
+	case PrecedenceFlash:
+		return "Flash"
+	default:
+		return "Routine"
+	}
+}
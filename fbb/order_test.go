@@ -0,0 +1,68 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrecedence(t *testing.T) {
+	tests := map[string]Precedence{
+		"//WL2K Z/ Evacuation":    PrecedenceFlash,
+		"//wl2k o/Shelter status": PrecedenceImmediate,
+		"//WL2K P/ Supplies":      PrecedencePriority,
+		"//WL2K R/ Weekly net":    PrecedenceRoutine,
+		"//WL2K X/ Unknown":       PrecedenceRoutine,
+		"//WL2K Z Missing slash":  PrecedenceRoutine,
+		"Z/ No prefix":            PrecedenceRoutine,
+	}
+	for subject, expect := range tests {
+		msg := NewMessage(Private, "LA5NTA")
+		msg.SetSubject(subject)
+		if got := msg.Precedence(); got != expect {
+			t.Errorf("%s: Expected %s, got %s", subject, expect, got)
+		}
+	}
+}
+
+func TestOrderingPolicy(t *testing.T) {
+	routine := testMessage("LA5NTA", "LA1B", 10)
+	routine.SetDate(time.Now().Add(-time.Hour))
+
+	flash := testMessage("LA5NTA", "LA1B", 5000) // E.g. with a photo
+	flash.SetSubject("//WL2K Z/ Evacuation route")
+
+	prioritized := testMessage("LA5NTA", "LA1B", 1000)
+	prioritized.SetPriority(5)
+
+	tests := []struct {
+		policy OrderingPolicy
+		expect []*Message
+	}{
+		{nil, []*Message{routine, prioritized, flash}},
+		{OrderBySize, []*Message{routine, prioritized, flash}},
+		{OrderByDate, []*Message{routine}},
+		{OrderByPrecedence, []*Message{flash}},
+		{OrderByPriority, []*Message{prioritized}},
+		{OrderingChain(OrderByPrecedence, OrderByPriority, OrderBySize), []*Message{flash, prioritized, routine}},
+	}
+	for i, test := range tests {
+		s := NewSession("LA5NTA", "LA1B", "JO39EQ", newMemMBox(routine, flash, prioritized))
+		s.SetLogger(discardLogger)
+		s.SetOrderingPolicy(test.policy)
+
+		props, _ := s.outbound()
+		got := make([]string, len(test.expect))
+		expect := make([]string, len(test.expect))
+		for j, msg := range test.expect {
+			got[j], expect[j] = props[j].MID(), msg.MID()
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("%d: Expected %v first, got %v", i, expect, got)
+		}
+	}
+}
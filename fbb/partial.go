@@ -0,0 +1,81 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+// The length of the header (CRC16 and uncompressed size) prepended to the compressed data.
+//
+// The header is always transferred, even when the remote requests an offset.
+const compressedHeaderLen = 6
+
+// A PartialStore persists partially received (compressed) messages, so that an interrupted
+// transfer can be resumed from where it stopped in a later session.
+//
+// If the Session's MBoxHandler implements this interface, it will be used by default.
+type PartialStore interface {
+	// GetPartial returns the compressed data received so far for the message identified by MID.
+	//
+	// A nil slice implies that no data is available.
+	GetPartial(MID string) []byte
+
+	// SetPartial should persist the compressed data received so far for the message identified by MID.
+	SetPartial(MID string, data []byte) error
+
+	// DeletePartial should discard any data stored for the message identified by MID.
+	DeletePartial(MID string) error
+}
+
+// SetPartialStore sets the PartialStore used to resume interrupted inbound transfers.
+//
+// A nil value disables resume of inbound messages.
+func (s *Session) SetPartialStore(store PartialStore) { s.partials = store }
+
+// resumeOffset returns the offset to request the given proposal from, based on
+// previously stored partial data.
+//
+// The proposal's compressed data is populated with the stored data when the returned offset is non-zero.
+func (s *Session) resumeOffset(p *Proposal) int {
+	if s.partials == nil || s.proto != protoB2F {
+		return 0 // The size of FBB compressed messages is unknown up front
+	}
+
+	data := s.partials.GetPartial(p.MID())
+	switch {
+	case len(data) <= compressedHeaderLen:
+		return 0
+	case len(data) >= p.compressedSize:
+		// Something is wrong (new version of the message?). Start over.
+		s.deletePartial(p)
+		return 0
+	case len(data) > ProtocolOffsetSizeLimit:
+		// The protocol can't express offsets larger than 6 digits, so we resume from
+		// the highest offset possible.
+		data = data[:ProtocolOffsetSizeLimit]
+	}
+
+	p.compressedData = data
+	return len(data)
+}
+
+func (s *Session) savePartial(p *Proposal, data []byte) {
+	if s.partials == nil || len(data) <= compressedHeaderLen {
+		return
+	}
+
+	if err := s.partials.SetPartial(p.MID(), data); err != nil {
+		s.log.Printf("Unable to save partially received message %s: %s", p.MID(), err)
+		return
+	}
+	s.log.Printf("Saved %d of %d bytes of partially received message %s", len(data), p.compressedSize, p.MID())
+}
+
+func (s *Session) deletePartial(p *Proposal) {
+	if s.partials == nil {
+		return
+	}
+
+	if err := s.partials.DeletePartial(p.MID()); err != nil {
+		s.log.Printf("Unable to delete partially received message %s: %s", p.MID(), err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// limitConn closes the underlying connection after n bytes has been written.
+type limitConn struct {
+	net.Conn
+	n int
+}
+
+func (c *limitConn) Write(p []byte) (int, error) {
+	if len(p) <= c.n {
+		c.n -= len(p)
+		return c.Conn.Write(p)
+	}
+
+	n, _ := c.Conn.Write(p[:c.n])
+	c.n = 0
+	c.Conn.Close()
+	return n, errors.New("connection interrupted")
+}
+
+// resumeRecorder records the offsets the receiving end resumed from.
+type resumeRecorder struct {
+	*memMBox
+	resumed []int
+}
+
+func (r *resumeRecorder) GetPartial(MID string) []byte {
+	data := r.memMBox.GetPartial(MID)
+	r.resumed = append(r.resumed, len(data))
+	return data
+}
+
+func TestResumeInterruptedTransfer(t *testing.T) {
+	msg := testMessage("N0CALL", "LA5NTA", 5000)
+
+	sender := newMemMBox(msg)
+	receiver := &resumeRecorder{memMBox: newMemMBox()}
+
+	// First attempt: The link dies half way through the transfer.
+	// The receiving end is master, so the sending end starts sending right after the handshake.
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", receiver)
+	master.SetLogger(discardLogger)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", sender)
+	client.SetLogger(discardLogger)
+
+	masterErr, clientErr := exchange(master, client, masterConn, &limitConn{Conn: clientConn, n: 2500})
+	if masterErr == nil || clientErr == nil {
+		t.Fatalf("Expected interrupted exchange, got errors %v and %v", masterErr, clientErr)
+	}
+	partial := receiver.memMBox.GetPartial(msg.MID())
+	if len(partial) <= compressedHeaderLen {
+		t.Fatalf("Expected partial data to be stored, got %d bytes", len(partial))
+	}
+	if len(receiver.in) != 0 {
+		t.Fatalf("Message was unexpectedly delivered")
+	}
+
+	// Second attempt: The transfer should resume from where it stopped.
+	masterConn, clientConn = net.Pipe()
+	master = NewSession("LA5NTA", "N0CALL", "JO39EQ", receiver)
+	master.SetLogger(discardLogger)
+	client = NewSession("N0CALL", "LA5NTA", "JO39EQ", sender)
+	client.SetLogger(discardLogger)
+
+	masterErr, clientErr = exchange(master, client, masterConn, clientConn)
+	if masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	if n := len(receiver.resumed); n == 0 || receiver.resumed[n-1] != len(partial) {
+		t.Errorf("Expected transfer to resume at offset %d, got %v", len(partial), receiver.resumed)
+	}
+	got, ok := receiver.in[msg.MID()]
+	if !ok {
+		t.Fatalf("Message not received after resume")
+	}
+	if body, _ := got.Body(); body != mustBody(t, msg) {
+		t.Errorf("Resumed message body does not match original")
+	}
+	if _, ok := sender.sent[msg.MID()]; !ok {
+		t.Errorf("Message not marked as sent")
+	}
+	if data := receiver.memMBox.GetPartial(msg.MID()); data != nil {
+		t.Errorf("Partial data not deleted after successful transfer")
+	}
+}
+
+func TestResumeMultipleTransfers(t *testing.T) {
+	msgs := []*Message{testMessage("N0CALL", "LA5NTA", 5000), testMessage("N0CALL", "LA5NTA", 6000)}
+	offsets := []int{1000, 1500}
+
+	sender := newMemMBox(msgs...)
+	receiver := &resumeRecorder{memMBox: newMemMBox()}
+
+	// Both messages were partially received in a previous session
+	for i, msg := range msgs {
+		prop, err := msg.Proposal(Wl2kProposal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiver.SetPartial(msg.MID(), prop.compressedData[:offsets[i]])
+	}
+
+	masterConn, clientConn := net.Pipe()
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", receiver)
+	master.SetLogger(discardLogger)
+	client := NewSession("N0CALL", "LA5NTA", "JO39EQ", sender)
+	client.SetLogger(discardLogger)
+	client.SetCodecs() // lzhuf only, as the partial data
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	for i, msg := range msgs {
+		got, ok := receiver.in[msg.MID()]
+		switch {
+		case !ok:
+			t.Errorf("%s not received after resume", msg.MID())
+		case mustBody(t, got) != mustBody(t, msg):
+			t.Errorf("%s: Resumed message body does not match original", msg.MID())
+		}
+		if !containsInt(receiver.resumed, offsets[i]) {
+			t.Errorf("Expected transfer to resume at offset %d, got %v", offsets[i], receiver.resumed)
+		}
+	}
+}
+
+func TestResumeOffsetLimit(t *testing.T) {
+	mbox := newMemMBox()
+	s := NewSession("LA5NTA", "N0CALL", "", mbox)
+	s.SetLogger(discardLogger)
+
+	p := &Proposal{mid: "TESTMID", compressedSize: ProtocolOffsetSizeLimit + 100}
+	mbox.SetPartial(p.MID(), make([]byte, ProtocolOffsetSizeLimit+10))
+
+	if offset := s.resumeOffset(p); offset != ProtocolOffsetSizeLimit {
+		t.Errorf("Expected offset %d, got %d", ProtocolOffsetSizeLimit, offset)
+	}
+
+	mbox.SetPartial(p.MID(), make([]byte, p.compressedSize))
+	if offset := s.resumeOffset(p); offset != 0 {
+		t.Errorf("Expected offset 0 for complete partial data, got %d", offset)
+	}
+	if mbox.GetPartial(p.MID()) != nil {
+		t.Errorf("Expected invalid partial data to be deleted")
+	}
+}
+
+func mustBody(t *testing.T, msg *Message) string {
+	body, err := msg.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func containsInt(slice []int, n int) bool {
+	for _, v := range slice {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -6,10 +6,10 @@ package fbb
 
 import (
 	"bytes"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 
@@ -22,7 +22,7 @@ const (
 	BasicProposal PropCode = 'B' // Basic ASCII proposal (or compressed binary in v0/1)
 	AsciiProposal          = 'A' // Compressed v0/1 ASCII proposal
 	Wl2kProposal           = 'C' // Compressed v2 proposal (winlink extension)
-	GzipProposal           = 'D' // Gzip compressed v2 proposal
+	GzipProposal           = 'D' // Gzip compressed v2 proposal (see GzipCodec)
 )
 
 type ProposalAnswer byte
@@ -32,7 +32,7 @@ const (
 	Reject                = '-'
 	Defer                 = '='
 
-	// Offset not supported yet
+	// Accept with offset (resume) is answered when partial data is available, see PartialStore.
 )
 
 // Proposal is the type representing a inbound or outbound proposal.
@@ -47,6 +47,10 @@ type Proposal struct {
 	size           int
 	compressedData []byte
 	compressedSize int
+
+	// FBB (non-B2) proposal fields
+	from, at, to string
+	compV0       bool // Compressed with FBB compressed protocol v0 (no CRC16 header)
 }
 
 // Constructor for a new Proposal given a Winlink Message.
@@ -56,38 +60,52 @@ type Proposal struct {
 // a Proposal with the given data.
 //
 func NewProposal(MID, title string, code PropCode, data []byte) *Proposal {
+	prop, err := NewProposalReader(MID, title, code, bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	return prop
+}
+
+// NewProposalReader is like NewProposal, but the raw message is read from r.
+//
+// The message is compressed as it's read, so only the compressed data is held in memory.
+func NewProposalReader(MID, title string, code PropCode, r io.Reader) (*Proposal, error) {
+	return newProposal(MID, title, code, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// newProposal returns a new proposal of the raw message written to the given writer by write.
+func newProposal(MID, title string, code PropCode, write func(w io.Writer) error) (*Proposal, error) {
 	prop := &Proposal{
 		mid:     MID,
 		code:    code,
 		msgType: "EM",
 		title:   title,
-		size:    len(data),
 	}
 
 	if prop.title == `` {
 		prop.title = `No title`
 	}
 
-	var (
-		z   io.WriteCloser
-		buf bytes.Buffer
-	)
-	switch prop.code {
-	case GzipProposal:
-		z, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
-	default:
-		z = lzhuf.NewB2Writer(&buf)
+	// Compress with the codec registered for the proposal code (see RegisterCodec)
+	var buf bytes.Buffer
+	z := lookupCodec(code).NewWriter(&buf)
+	raw := &countingWriter{Writer: z}
+	if err := write(raw); err != nil {
+		return nil, err
 	}
-
-	z.Write(data)
 	if err := z.Close(); err != nil {
-		panic(err)
+		return nil, err
 	}
 
+	prop.size = int(raw.n)
 	prop.compressedData = buf.Bytes()
 	prop.compressedSize = len(prop.compressedData)
 
-	return prop
+	return prop, nil
 }
 
 // Method for checking if the Proposal is completely
@@ -110,35 +128,102 @@ func (p *Proposal) Title() string {
 	return p.title
 }
 
-func (p *Proposal) Message() (*Message, error) {
-	buf := bytes.NewBuffer(p.Data())
+func (p *Proposal) Message() (*Message, error) { return p.message("", -1) }
+
+// message returns the message of the proposal, spooling attachments larger than spoolThreshold
+// bytes to spoolDir (see Message.ReadFromSpool). A negative threshold disables spooling.
+func (p *Proposal) message(spoolDir string, spoolThreshold int) (*Message, error) {
+	if p.code == BasicProposal || p.code == AsciiProposal {
+		data, err := p.data()
+		if err != nil {
+			return nil, checksumError(p.MID(), err)
+		}
+		return p.fbbMessage(data)
+	}
+
+	// The message is parsed as it's decompressed, so the raw message is never held in memory
+	r, err := p.reader()
+	if err != nil {
+		return nil, checksumError(p.MID(), err)
+	}
+
 	m := new(Message)
-	err := m.ReadFrom(buf)
+	err = m.readFrom(r, spoolDir, spoolThreshold)
+
+	// Consume any trailing data, so that the checksum is verified
+	if _, drainErr := io.Copy(ioutil.Discard, r); err == nil {
+		err = drainErr
+	}
+	if closeErr := r.Close(); closeErr != nil {
+		m.removeSpooled()
+		return nil, checksumError(p.MID(), closeErr)
+	}
 	return m, err
 }
 
-// Data returns the decompressed raw message
+// Data returns the decompressed raw message, or nil if the data is corrupted.
+//
+// Deprecated: Use ReadData, which reports the error.
 func (p *Proposal) Data() []byte {
-	var r io.ReadCloser
-	var err error
+	data, _ := p.ReadData()
+	return data
+}
 
-	switch p.code {
-	case GzipProposal:
-		r, err = gzip.NewReader(bytes.NewBuffer(p.compressedData))
-	default:
-		r, err = lzhuf.NewB2Reader(bytes.NewBuffer(p.compressedData))
+// ReadData returns the decompressed raw message.
+//
+// A *ChecksumError is returned if the data is corrupted.
+func (p *Proposal) ReadData() ([]byte, error) {
+	data, err := p.data()
+	if err != nil {
+		return nil, checksumError(p.MID(), err)
+	}
+	return data, nil
+}
+
+// data returns the decompressed raw message.
+//
+// A checksum mismatch is reported as lzhuf.ErrChecksum for the lzhuf codecs.
+func (p *Proposal) data() ([]byte, error) {
+	if p.code == BasicProposal {
+		return p.compressedData, nil // Not compressed
 	}
 
+	r, err := p.reader()
 	if err != nil {
-		panic(err) //TODO: Should return error
+		return nil, err
 	}
 
 	var buf bytes.Buffer
 	if _, err := io.Copy(&buf, r); err != nil {
-		panic(err) //TODO
+		return nil, err
 	}
+	return buf.Bytes(), r.Close()
+}
+
+// countingWriter counts the bytes written to the underlying Writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
 
-	return buf.Bytes()
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// reader returns a reader decompressing the raw message.
+//
+// A checksum mismatch is reported by Close as lzhuf.ErrChecksum for the lzhuf codecs.
+func (p *Proposal) reader() (io.ReadCloser, error) {
+	switch p.code {
+	case BasicProposal:
+		return ioutil.NopCloser(bytes.NewReader(p.compressedData)), nil // Not compressed
+	case AsciiProposal:
+		return lzhuf.NewReader(bytes.NewReader(p.compressedData), !p.compV0)
+	default:
+		return lookupCodec(p.code).NewReader(bytes.NewReader(p.compressedData))
+	}
 }
 
 func parseProposal(line string, prop *Proposal) (err error) {
@@ -150,11 +235,11 @@ func parseProposal(line string, prop *Proposal) (err error) {
 
 	prop.code = PropCode(line[1])
 
-	switch prop.code {
-	case BasicProposal, AsciiProposal: // TODO: implement
-	case Wl2kProposal, GzipProposal:
+	if prop.code == BasicProposal || prop.code == AsciiProposal {
+		err = parseFBBProposal(line, prop)
+	} else if _, ok := LookupCodec(prop.code); ok {
 		err = parseB2Proposal(line, prop)
-	default:
+	} else {
 		err = fmt.Errorf("Unsupported proposal code '%c'", prop.code)
 	}
 	return
@@ -165,8 +250,8 @@ func parseB2Proposal(line string, prop *Proposal) (err error) {
 		return errors.New("Unexpected end of proposal line")
 	}
 
-	if !(line[1] == Wl2kProposal || line[1] == GzipProposal) {
-		return errors.New("Not a type C or D proposal")
+	if _, ok := LookupCodec(PropCode(line[1])); !ok {
+		return errors.New("Not a B2 proposal")
 	}
 
 	// FC EM TJKYEIMMHSRB 527 123 0
@@ -180,11 +265,14 @@ func parseB2Proposal(line string, prop *Proposal) (err error) {
 		case 0:
 			if len(part) < 1 || len(part) > 2 {
 				return errors.New(`Malformed proposal 0`)
-			} else if part != "EM" && part != "CM" {
+			} else if part != "EM" && part != "CM" && part != batchMsgType {
 				return fmt.Errorf(`Expected message type CM or EM, but found %s`, part)
 			}
 			prop.msgType = part
 		case 1:
+			if err := ValidateMID(part); err != nil {
+				return fmt.Errorf("Invalid MID %q: %w", part, err)
+			}
 			prop.mid = part
 		case 2:
 			prop.size, _ = strconv.Atoi(part)
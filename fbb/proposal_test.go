@@ -30,4 +30,15 @@ func TestParseProposal(t *testing.T) {
 			t.Errorf("Got %#v, expected %#v while parsing '%s'", got, expected, input)
 		}
 	}
+
+	for _, input := range []string{
+		"FC EM ../../../x 527 123 0",
+		"FC EM TJKYEIMMHSRB1 527 123 0",
+		`FC EM ..\x 527 123 0`,
+		"FB P F6FBB FC1GHV FC1MVP ../24657 1345",
+	} {
+		if err := parseProposal(input, &Proposal{}); err == nil {
+			t.Errorf("Expected error for invalid MID in '%s'", input)
+		}
+	}
 }
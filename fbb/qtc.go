@@ -0,0 +1,109 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// This file implements the message-waiting indication (QTC) of the handshake (SID flag I).
+//
+// The number of messages waiting for the remote is announced on a line like
+// "; N0CALL DE LA5NTA QTC 3" (as sent by paclink-unix), before the identification
+// line. The client only announces it if the remote advertises the I flag.
+//
+// The master sends its handshake before the remote's SID is known, and before the
+// remote has responded to the secure login challenge. Announcing is therefore
+// opt-in for masters (see SetMasterQTC), and never done when the remote's secure
+// login is to be verified (see SetPasswordLookup).
+
+// qtcRe matches the QTC line (ie. ; N0CALL DE LA5NTA QTC 3).
+var qtcRe = regexp.MustCompile(`(?i)^;\s*(\S+)\s+DE\s+(\S+)\s+QTC\s+(\d+)`)
+
+// SetQTC enables the message-waiting indication (SID flag I).
+//
+// When enabled, the number of outbound messages waiting for the remote is announced during handshake
+// (as master, only if enabled by SetMasterQTC).
+// The count announced by the remote is available regardless of this setting, through an EventQTC
+// event before the transfer starts, and RemoteInfo after the handshake. To end the session early (e.g.
+// when too many messages are waiting on a marginal link), cancel the context given to ExchangeContext
+// from the EventObserver.
+func (s *Session) SetQTC(enabled bool) { s.qtc = enabled }
+
+// SetMasterQTC enables the QTC announcement when the local node is session master (requires SetQTC).
+//
+// The master announces the count to any remote, regardless of the remote's SID. It is never
+// announced when a PasswordLookup is set, as the remote is not verified before the handshake
+// is sent.
+func (s *Session) SetMasterQTC(enabled bool) { s.masterQTC = enabled }
+
+// qtcSID returns the I flag if the message-waiting indication is enabled.
+func (s *Session) qtcSID() string {
+	if !s.qtc {
+		return ""
+	}
+	return sI
+}
+
+// writeQTC writes the QTC line announcing the number of messages waiting for the remote, if enabled.
+func (s *Session) writeQTC(w io.Writer) error {
+	switch {
+	case !s.qtc:
+		return nil
+	case s.master && (!s.masterQTC || s.passwordLookup != nil):
+		return nil // Don't leak traffic information to an unknown (or unverified) remote
+	case !s.master && !s.remoteSID.Has(sI):
+		return nil
+	}
+
+	n, err := s.waiting()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "; %s DE %s QTC %d\r", s.targetcall, s.mycall, n)
+	return nil
+}
+
+// waiting returns the number of outbound messages waiting for the remote.
+func (s *Session) waiting() (int, error) {
+	if s.h == nil {
+		return 0, nil
+	}
+
+	// The master sends the handshake before the remote's forwarder addresses are known
+	fw := s.remoteFW
+	if s.master {
+		fw = []Address{AddressFromString(s.targetcall)}
+	}
+
+	msgs, err := s.h.GetOutbound(s.ctx, fw...)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to get outbound messages: %w", err)
+	}
+
+	var n int
+	for _, m := range msgs {
+		if m.Validate() == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// parseQTCLine parses the QTC line, returning the number of messages waiting (-1 if malformed).
+func parseQTCLine(line string) int {
+	m := qtcRe.FindStringSubmatch(line)
+	if m == nil {
+		return -1
+	}
+	n, err := strconv.Atoi(m[3])
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"net"
+	"testing"
+)
+
+func TestQTC(t *testing.T) {
+	tests := []struct {
+		masterQTC, clientQTC       bool
+		masterAnnounce, secure     bool // SetMasterQTC and secure login verification
+		expectMaster, expectClient int  // The remote's QTC as seen by master and client
+	}{
+		{true, true, true, false, 1, 2},
+		{true, false, true, false, -1, 2}, // The master announces regardless of the remote's SID
+		{true, true, false, false, 1, -1}, // Announcing is opt-in for masters
+		{true, true, true, true, 1, -1},   // Never announced before the secure login is verified
+		{false, true, true, false, -1, -1},
+		{false, false, true, false, -1, -1},
+	}
+
+	for i, test := range tests {
+		masterConn, clientConn := net.Pipe()
+		master := NewSession("LA5NTA", "N0CALL", "JO39EQ", newMemMBox(
+			testMessage("LA5NTA", "N0CALL", 100),
+			testMessage("LA5NTA", "N0CALL", 200),
+		))
+		master.SetLogger(discardLogger)
+		master.SetQTC(test.masterQTC)
+		master.SetMasterQTC(test.masterAnnounce)
+		if test.secure {
+			master.SetPasswordLookup(passwordMap{"N0CALL": "FOOBAR"})
+		}
+
+		var events eventRecorder
+		client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(testMessage("N0CALL", "LA5NTA", 100)))
+		client.SetLogger(discardLogger)
+		client.SetQTC(test.clientQTC)
+		client.SetEventObserver(&events)
+		client.SetSecureLoginHandleFunc(func(Address) (string, error) { return "FOOBAR", nil })
+
+		if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+			t.Fatalf("%d: Exchange failed: %v, %v", i, masterErr, clientErr)
+		}
+
+		if got := master.RemoteInfo().QTC; got != test.expectMaster {
+			t.Errorf("%d: Expected master to see QTC %d, got %d", i, test.expectMaster, got)
+		}
+		if got := client.RemoteInfo().QTC; got != test.expectClient {
+			t.Errorf("%d: Expected client to see QTC %d, got %d", i, test.expectClient, got)
+		}
+
+		// The count is reported before any proposal
+		for _, e := range events {
+			if e.Type == EventProposal {
+				if test.expectClient >= 0 {
+					t.Errorf("%d: Expected EventQTC before the first proposal", i)
+				}
+				break
+			}
+			if e.Type == EventQTC {
+				if e.QTC != test.expectClient {
+					t.Errorf("%d: Unexpected EventQTC count %d", i, e.QTC)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestParseQTCLine(t *testing.T) {
+	tests := map[string]int{
+		";LA5NTA DE N0CALL QTC 3":     3,
+		"; LA5NTA DE N0CALL QTC 0":    0,
+		"; la5nta de n0call qtc 12":   12,
+		"; LA5NTA DE N0CALL (JO39EQ)": -1,
+	}
+	for line, expect := range tests {
+		if got := parseQTCLine(line); got != expect {
+			t.Errorf("%q: Expected %d, got %d", line, expect, got)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RemoteInfo holds the identity and capabilities of the remote, as reported during handshake.
+type RemoteInfo struct {
+	Software string // The software name from the SID header (e.g. WL2K or RMS Express)
+	Version  string // The software version from the SID header (e.g. 2.8.4.8)
+
+	SID          string       // The raw SID codes (e.g. B2FWIHJM$)
+	Capabilities Capabilities // The capabilities parsed from the SID codes
+
+	Callsign string // The remote's call sign, from the "; X DE Y (GRID)" line
+	Locator  string // The remote's locator, from the "; X DE Y (GRID)" line
+
+	Forwarders []Address // Addresses the remote requests messages on behalf of
+	Banner     []string  // MOTD and banner lines received before the prompt
+
+	QTC int // The number of messages the remote has waiting for us, or -1 if not announced (see SetQTC)
+}
+
+// Capabilities is a set of protocol capabilities advertised in a SID.
+type Capabilities uint
+
+// The capabilities known to this package.
+const (
+	CapFBBBasic        Capabilities = 1 << iota // FBB basic ascii protocol (F)
+	CapFBBCompressedV0                          // FBB compressed protocol v0 (B)
+	CapFBBCompressedV1                          // FBB compressed protocol v1 (B1)
+	CapB2F                                      // FBB compressed protocol v2, aka B2F (B2)
+	CapHierarchical                             // Hierarchical location designators (H)
+	CapMID                                      // Message identifiers (M)
+	CapBID                                      // Bulletin identifiers ($)
+	CapAck                                      // Acknowledge for personal messages (A)
+	CapBatch                                    // Compressed batch forwarding (X)
+	CapQTC                                      // Reports the number of messages waiting (I)
+	CapGzip                                     // Gzip compressed messages (G)
+)
+
+var capabilityNames = []struct {
+	c    Capabilities
+	name string
+}{
+	{CapFBBBasic, "FBB basic"},
+	{CapFBBCompressedV0, "FBB compressed v0"},
+	{CapFBBCompressedV1, "FBB compressed v1"},
+	{CapB2F, "B2F"},
+	{CapHierarchical, "hierarchical"},
+	{CapMID, "MID"},
+	{CapBID, "BID"},
+	{CapAck, "ack"},
+	{CapBatch, "batch"},
+	{CapQTC, "QTC"},
+	{CapGzip, "gzip"},
+}
+
+// Has returns true if all of the given capabilities are in the set.
+func (c Capabilities) Has(caps Capabilities) bool { return c&caps == caps }
+
+func (c Capabilities) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c.Has(n.c) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseCapabilities returns the known capabilities of the given SID codes.
+func parseCapabilities(codes sid) Capabilities {
+	str := strings.ToUpper(string(codes))
+
+	var c Capabilities
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case 'B':
+			switch {
+			case strings.HasPrefix(str[i:], sFBComp2):
+				c |= CapB2F
+				i++
+			case strings.HasPrefix(str[i:], sFBComp1):
+				c |= CapFBBCompressedV1
+				i++
+			default:
+				c |= CapFBBCompressedV0
+			}
+		case sFBBasic[0]:
+			c |= CapFBBBasic
+		case sHL[0]:
+			c |= CapHierarchical
+		case sMID[0]:
+			c |= CapMID
+		case sBID[0]:
+			c |= CapBID
+		case sAckForPM[0]:
+			c |= CapAck
+		case sCompBatchF[0]:
+			c |= CapBatch
+		case sI[0]:
+			c |= CapQTC
+		case sGzip[0]:
+			c |= CapGzip
+		}
+	}
+	return c
+}
+
+// parseSIDHeader returns the software name and version from a SID header (ie. [WL2K-2.8.4.8-B2FWIHJM$]).
+func parseSIDHeader(line string) (name, version string) {
+	start, end := strings.Index(line, "["), strings.LastIndex(line, "]")
+	if start < 0 || end < start {
+		return "", ""
+	}
+
+	parts := strings.Split(line[start+1:end], "-")
+	switch len(parts) {
+	case 1:
+		return "", "" // SID only
+	case 2:
+		return parts[0], "" // No version
+	}
+	return parts[0], strings.Join(parts[1:len(parts)-1], "-")
+}
+
+var identRe = regexp.MustCompile(`(?i)^;\s*(\S+)\s+DE\s+([^\s(>]+)\s*(?:\(([^)]*)\))?`)
+
+// parseIdentLine parses the "; X DE Y (GRID)" line, returning the call sign and locator of Y.
+func parseIdentLine(line string) (call, locator string) {
+	m := identRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", ""
+	}
+	return strings.ToUpper(m[2]), strings.TrimSpace(m[3])
+}
+
+// RemoteInfo returns the identity and capabilities of the remote. It is not available until the handshake is done.
+//
+// The String method gives a one-line summary suitable for logging.
+func (s *Session) RemoteInfo() RemoteInfo { return s.remote }
+
+// VersionAtLeast returns true if the remote runs the given software (case-insensitive) with a version equal to or
+// greater than the given version.
+//
+// Versions are compared numerically, component by component (ie. 2.10 is greater than 2.9). Trailing non-numeric
+// characters of a component are ignored.
+func (r RemoteInfo) VersionAtLeast(software, version string) bool {
+	if !strings.EqualFold(r.Software, software) {
+		return false
+	}
+	have, want := strings.Split(r.Version, "."), strings.Split(version, ".")
+	for i := range want {
+		var h int
+		if i < len(have) {
+			h = versionComponent(have[i])
+		}
+		if w := versionComponent(want[i]); h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+func versionComponent(str string) int {
+	end := strings.IndexFunc(str, func(r rune) bool { return r < '0' || r > '9' })
+	if end >= 0 {
+		str = str[:end]
+	}
+	n, _ := strconv.Atoi(str)
+	return n
+}
+
+func (r RemoteInfo) String() string {
+	str := r.Callsign
+	if str == "" {
+		str = "unknown"
+	}
+	if r.Locator != "" {
+		str += fmt.Sprintf(" (%s)", r.Locator)
+	}
+	if r.Software != "" {
+		str += fmt.Sprintf(" running %s %s", r.Software, r.Version)
+	}
+	return str + fmt.Sprintf(" [%s]", r.SID)
+}
+
+func newRemoteInfo(hs handshakeData) RemoteInfo {
+	name, version := parseSIDHeader(hs.SIDHeader)
+	return RemoteInfo{
+		Software:     name,
+		Version:      version,
+		SID:          string(hs.SID),
+		Capabilities: parseCapabilities(hs.SID),
+		Callsign:     hs.Callsign,
+		Locator:      hs.Locator,
+		Forwarders:   hs.FW,
+		Banner:       hs.Banner,
+		QTC:          hs.QTC,
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := map[sid]Capabilities{
+		"B2FWIHJM$": CapB2F | CapFBBBasic | CapQTC | CapHierarchical | CapMID | CapBID,
+		"AB1FHMRX$": CapAck | CapFBBCompressedV1 | CapFBBBasic | CapHierarchical | CapMID | CapBatch | CapBID,
+		"BFHM$":     CapFBBCompressedV0 | CapFBBBasic | CapHierarchical | CapMID | CapBID,
+		"b2fhmg$":   CapB2F | CapFBBBasic | CapHierarchical | CapMID | CapGzip | CapBID,
+	}
+	for codes, expect := range tests {
+		if got := parseCapabilities(codes); got != expect {
+			t.Errorf("%s: Expected %s, got %s", codes, expect, got)
+		}
+	}
+}
+
+func TestParseSIDHeader(t *testing.T) {
+	tests := map[string][2]string{
+		"[WL2K-2.8.4.8-B2FWIHJM$]":       {"WL2K", "2.8.4.8"},
+		"[RMS Express-1.5.1.0-B2FHM$]":   {"RMS Express", "1.5.1.0"},
+		"[FBB-5.11-FHM$]":                {"FBB", "5.11"},
+		"[BPQ-6.0.20.1-rc1-B1FWIHJM$]":   {"BPQ", "6.0.20.1-rc1"},
+		"Welcome [wl2kgo-0.1a-B2FHM$] >": {"wl2kgo", "0.1a"},
+		"[FBB-FHM$]":                     {"FBB", ""},
+		"[B2FHM$]":                       {"", ""},
+	}
+	for line, expect := range tests {
+		if name, version := parseSIDHeader(line); name != expect[0] || version != expect[1] {
+			t.Errorf("%s: Expected %q, got %q", line, expect, [2]string{name, version})
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	r := RemoteInfo{Software: "WL2K", Version: "2.10.1a"}
+	tests := []struct {
+		software, version string
+		expect            bool
+	}{
+		{"WL2K", "2.10.1", true},
+		{"wl2k", "2.9", true},
+		{"WL2K", "2.10.1.1", false},
+		{"WL2K", "3", false},
+		{"RMS Express", "1.0", false},
+	}
+	for _, test := range tests {
+		if got := r.VersionAtLeast(test.software, test.version); got != test.expect {
+			t.Errorf("%s %s: Expected %t, got %t", test.software, test.version, test.expect, got)
+		}
+	}
+}
+
+func TestRemoteInfo(t *testing.T) {
+	masterConn, clientConn := net.Pipe()
+
+	master := NewSession("LA5NTA", "N0CALL", "JO39EQ", newMemMBox())
+	master.SetLogger(discardLogger)
+	master.SetMOTD("Welcome to LA5NTA", "*** Be nice")
+
+	client := NewSession("N0CALL", "LA5NTA", "JO20", newMemMBox())
+	client.SetLogger(discardLogger)
+
+	if masterErr, clientErr := exchange(master, client, masterConn, clientConn); masterErr != nil || clientErr != nil {
+		t.Fatalf("Exchange failed: %v, %v", masterErr, clientErr)
+	}
+
+	expect := RemoteInfo{
+		Software:     StdUA.Name,
+		Version:      StdUA.Version,
+		SID:          localSID,
+		Capabilities: CapB2F | CapFBBBasic | CapHierarchical | CapMID | CapBID,
+		Callsign:     "LA5NTA",
+		Locator:      "JO39EQ",
+		Forwarders:   []Address{AddressFromString("LA5NTA")},
+		Banner:       []string{"Welcome to LA5NTA", "*** Be nice"},
+		QTC:          -1,
+	}
+	if got := client.RemoteInfo(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected client remote info:\n%+v\n%+v", got, expect)
+	}
+	if got := master.RemoteInfo(); got.Callsign != "N0CALL" || got.Locator != "JO20" || len(got.Banner) != 0 {
+		t.Errorf("Unexpected master remote info: %+v", got)
+	}
+}
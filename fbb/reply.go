@@ -0,0 +1,158 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxReferences is the number of MIDs kept in the References header of replies and forwarded messages.
+const maxReferences = 10
+
+// Reply returns a new message replying to m, from mycall.
+//
+// The reply is addressed to the sender of m. If all is true, it's also addressed to the other
+// receivers of m (To and Cc are kept), except mycall. The subject is prefixed with "Re:", and the
+// body quotes the body of m with its sender and date. The reply text can be prepended using
+// Body and SetBody.
+//
+// The In-Reply-To and References headers are set to thread the reply with m.
+func (m *Message) Reply(mycall string, all bool) *Message {
+	reply := NewMessage(Private, mycall)
+	reply.setThread(m, true)
+	reply.SetSubject(prefixSubject("Re:", m.Subject()))
+
+	var (
+		to, cc []Address
+		seen   = map[string]bool{strings.ToUpper(AddressFromString(mycall).String()): true}
+	)
+	add := func(list []Address, addrs ...Address) []Address {
+		for _, a := range addrs {
+			if key := strings.ToUpper(a.String()); !a.IsZero() && !seen[key] {
+				seen[key] = true
+				list = append(list, a)
+			}
+		}
+		return list
+	}
+
+	to = add(to, m.From())
+	if all || len(to) == 0 {
+		// Replying to all, or to our own message (e.g. from the sent folder)
+		to = add(to, m.To()...)
+	}
+	if all {
+		cc = add(cc, m.Cc()...)
+	}
+	for _, a := range to {
+		reply.AddTo(a.String())
+	}
+	for _, a := range cc {
+		reply.AddCc(a.String())
+	}
+
+	body, _ := m.Body()
+	var quote strings.Builder
+	fmt.Fprintf(&quote, "On %s UTC, %s wrote:\n", m.Date().UTC().Format(DateLayout), m.From())
+	for _, line := range bodyLines(body) {
+		if line == "" {
+			quote.WriteString(">\n")
+		} else {
+			quote.WriteString("> " + line + "\n")
+		}
+	}
+	reply.setBodyCharset(m.Charset(), quote.String())
+
+	return reply
+}
+
+// Forward returns a new message forwarding m, from mycall.
+//
+// The subject is prefixed with "Fwd:", and the body holds the sender, date, receivers and body of m.
+// The attachments of m are carried over. As with Reply, any text can be prepended to the body. The
+// receivers must be added (see AddTo) before the message is valid.
+//
+// The References header is set to thread the message with m.
+func (m *Message) Forward(mycall string) *Message {
+	fwd := NewMessage(Private, mycall)
+	fwd.setThread(m, false)
+	fwd.SetSubject(prefixSubject("Fwd:", m.Subject()))
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- Forwarded message ---\n")
+	fmt.Fprintf(&buf, "From: %s\n", m.From())
+	fmt.Fprintf(&buf, "Date: %s UTC\n", m.Date().UTC().Format(DateLayout))
+	if to := m.To(); len(to) > 0 {
+		fmt.Fprintf(&buf, "To: %s\n", joinAddresses(to))
+	}
+	if cc := m.Cc(); len(cc) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\n", joinAddresses(cc))
+	}
+	fmt.Fprintf(&buf, "Subject: %s\n\n", m.Subject())
+
+	body, _ := m.Body()
+	for _, line := range bodyLines(body) {
+		buf.WriteString(line + "\n")
+	}
+	fwd.setBodyCharset(m.Charset(), buf.String())
+
+	for _, f := range m.Files() {
+		fwd.AddFile(f)
+	}
+
+	return fwd
+}
+
+// setThread sets the headers threading m with the given message it replies to (or forwards).
+func (m *Message) setThread(orig *Message, reply bool) {
+	if reply {
+		m.Header.Set(HEADER_IN_REPLY_TO, orig.MID())
+	}
+
+	refs := append(strings.Fields(orig.Header.Get(HEADER_REFERENCES)), orig.MID())
+	if len(refs) > maxReferences {
+		refs = refs[len(refs)-maxReferences:]
+	}
+	m.Header.Set(HEADER_REFERENCES, strings.Join(refs, " "))
+}
+
+// prefixSubject returns the subject prefixed with prefix (e.g. "Re:"), unless it's already prefixed.
+//
+// The subject is truncated to fit MaxSubjectLength when encoded.
+func prefixSubject(prefix, subject string) string {
+	subject = strings.TrimSpace(subject)
+	if !strings.HasPrefix(strings.ToLower(subject), strings.ToLower(prefix)) {
+		subject = prefix + " " + subject
+	}
+
+	return strings.TrimSpace(truncateSubject(subject))
+}
+
+// truncateSubject returns the subject truncated (by rune) to fit MaxSubjectLength when encoded.
+func truncateSubject(subject string) string {
+	for len(encodeSubject(subject)) > MaxSubjectLength {
+		runes := []rune(subject)
+		subject = string(runes[:len(runes)-1])
+	}
+	return subject
+}
+
+// bodyLines returns the lines of the body, without line breaks and trailing empty lines.
+func bodyLines(body string) []string {
+	lines := strings.Split(strings.Replace(body, "\r\n", "\n", -1), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func joinAddresses(addrs []Address) string {
+	strs := make([]string, len(addrs))
+	for i, a := range addrs {
+		strs[i] = a.String()
+	}
+	return strings.Join(strs, ", ")
+}
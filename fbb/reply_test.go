@@ -0,0 +1,127 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func replyTestMessage() *Message {
+	msg := NewMessage(Private, "LA5NTA")
+	msg.Header.Set(HEADER_MID, "ORIGINAL1234")
+	msg.SetDate(time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC))
+	msg.AddTo("N0CALL", "N1CALL")
+	msg.AddCc("N2CALL", "foo@example.com")
+	msg.SetSubject("Net tonight")
+	msg.SetBody("Net at 2000 local.\n\n73")
+	return msg
+}
+
+func TestReply(t *testing.T) {
+	orig := replyTestMessage()
+
+	tests := []struct {
+		all    bool
+		to, cc string
+	}{
+		{false, "LA5NTA", ""},
+		{true, "LA5NTA N1CALL", "N2CALL SMTP:foo@example.com"},
+	}
+	for _, test := range tests {
+		reply := orig.Reply("n0call", test.all)
+		if err := reply.Validate(); err != nil {
+			t.Errorf("all=%t: Invalid reply: %s", test.all, err)
+		}
+		if to, cc := joinAddresses(reply.To()), joinAddresses(reply.Cc()); to != strings.Replace(test.to, " ", ", ", -1) || cc != strings.Replace(test.cc, " ", ", ", -1) {
+			t.Errorf("all=%t: Unexpected receivers To %q, Cc %q", test.all, to, cc)
+		}
+	}
+
+	reply := orig.Reply("N0CALL", false)
+	if reply.Subject() != "Re: Net tonight" {
+		t.Errorf("Unexpected subject %q", reply.Subject())
+	}
+	expectBody := "On 2020/05/17 12:30 UTC, LA5NTA wrote:\r\n> Net at 2000 local.\r\n>\r\n> 73\r\n"
+	if body, _ := reply.Body(); body != expectBody {
+		t.Errorf("Unexpected body %q", body)
+	}
+	if reply.Header.Get(HEADER_IN_REPLY_TO) != orig.MID() || reply.Header.Get(HEADER_REFERENCES) != orig.MID() {
+		t.Errorf("Unexpected threading headers %v", reply.Header)
+	}
+
+	// Replying to the reply
+	second := reply.Reply("LA5NTA", false)
+	switch {
+	case second.Subject() != "Re: Net tonight":
+		t.Errorf("Unexpected subject of second reply %q", second.Subject())
+	case second.Header.Get(HEADER_REFERENCES) != orig.MID()+" "+reply.MID():
+		t.Errorf("Unexpected references of second reply %q", second.Header.Get(HEADER_REFERENCES))
+	case joinAddresses(second.To()) != "N0CALL":
+		t.Errorf("Unexpected receivers of second reply %v", second.To())
+	}
+
+	// Replying to our own message (e.g. from the sent folder)
+	if own := orig.Reply("LA5NTA", false); joinAddresses(own.To()) != "N0CALL, N1CALL" {
+		t.Errorf("Unexpected receivers of reply to own message %v", own.To())
+	}
+}
+
+func TestReplyLongSubject(t *testing.T) {
+	orig := replyTestMessage()
+	orig.Header.Set(HEADER_SUBJECT, strings.Repeat("x", MaxSubjectLength))
+
+	for _, msg := range []*Message{orig.Reply("N0CALL", false), orig.Forward("N0CALL")} {
+		msg.AddTo("N3CALL")
+		if err := msg.Validate(); err != nil {
+			t.Errorf("Invalid message %q: %s", msg.Subject(), err)
+		}
+	}
+
+	// Non-ASCII characters are Q-encoded, and must be accounted for
+	orig.SetSubject(strings.Repeat("æ", 40))
+	if reply := orig.Reply("N0CALL", false); reply.Validate() != nil || !strings.HasPrefix(reply.Subject(), "Re: æ") {
+		t.Errorf("Unexpected reply subject %q: %v", reply.Subject(), reply.Validate())
+	}
+}
+
+func TestForward(t *testing.T) {
+	orig := replyTestMessage()
+	orig.AddFile(NewFile("net.txt", []byte("Net control: LA5NTA")))
+
+	fwd := orig.Forward("N0CALL")
+	if err := fwd.Validate(); err == nil {
+		t.Errorf("Expected forwarded message without receivers to be invalid")
+	}
+	fwd.AddTo("N3CALL")
+	if err := fwd.Validate(); err != nil {
+		t.Errorf("Invalid forwarded message: %s", err)
+	}
+
+	switch {
+	case fwd.Subject() != "Fwd: Net tonight":
+		t.Errorf("Unexpected subject %q", fwd.Subject())
+	case fwd.From().String() != "N0CALL":
+		t.Errorf("Unexpected sender %s", fwd.From())
+	case fwd.Header.Get(HEADER_IN_REPLY_TO) != "" || fwd.Header.Get(HEADER_REFERENCES) != orig.MID():
+		t.Errorf("Unexpected threading headers %v", fwd.Header)
+	case len(fwd.Files()) != 1 || fwd.Files()[0].Name() != "net.txt" || fwd.Header.Get(HEADER_FILE) != orig.Header.Get(HEADER_FILE):
+		t.Errorf("Attachments not carried over")
+	}
+
+	body, _ := fwd.Body()
+	for _, expect := range []string{
+		"From: LA5NTA\r\n",
+		"Date: 2020/05/17 12:30 UTC\r\n",
+		"To: N0CALL, N1CALL\r\n",
+		"Cc: N2CALL, SMTP:foo@example.com\r\n",
+		"Subject: Net tonight\r\n\r\nNet at 2000 local.\r\n\r\n73\r\n",
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("Expected %q in body:\n%s", expect, body)
+		}
+	}
+}
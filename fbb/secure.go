@@ -6,9 +6,26 @@ package fbb
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"fmt"
+	"math/big"
 )
 
+// A PasswordLookup provides the secure login passwords used to authenticate the remote
+// when the local node is session master.
+type PasswordLookup interface {
+	// LookupPassword returns the secure login password of the given address.
+	//
+	// If ok is false, the address is not allowed to log in.
+	LookupPassword(addr Address) (password string, ok bool)
+}
+
+// SetPasswordLookup enables secure login challenge of the remote when the local node is session master.
+//
+// The remote's response (and individual responses for auxiliary addresses) is verified using the
+// passwords provided by l. If verification fails, the session is refused with ErrSecureLoginFailed.
+func (s *Session) SetPasswordLookup(l PasswordLookup) { s.passwordLookup = l }
+
 // This salt was found in paclink-unix's source code.
 var winlinkSecureSalt = []byte{
 	77, 197, 101, 206, 190, 249,
@@ -23,6 +40,15 @@ var winlinkSecureSalt = []byte{
 	41, 45, 240, 16, 29, 228,
 	208, 228, 61, 20}
 
+// newSecureChallenge returns a new random 8 digit secure login challenge.
+func newSecureChallenge() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(100000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%08d", n.Int64())
+}
+
 // This algorithm for generating a secure login response token has been ported
 // to Go from the paclink-unix implementation.
 func secureLoginResponse(challenge, password string) string {
@@ -4,7 +4,12 @@
 
 package fbb
 
-import "testing"
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
 
 func TestSecureLoginResponse(t *testing.T) {
 	type test struct{ challenge, password, expect string }
@@ -26,3 +31,56 @@ func BenchmarkSecureLoginResponse(b *testing.B) {
 		secureLoginResponse("23753528", "foobar")
 	}
 }
+
+type passwordMap map[string]string
+
+func (m passwordMap) LookupPassword(addr Address) (string, bool) {
+	password, ok := m[addr.Addr]
+	return password, ok
+}
+
+func TestSecureLoginVerification(t *testing.T) {
+	accounts := passwordMap{"LA5NTA": "FOOBAR", "LA5NTA-1": "FooBar"}
+
+	tests := []struct {
+		passwords map[string]string
+		expectOK  bool
+	}{
+		{map[string]string{"LA5NTA": "FOOBAR", "LA5NTA-1": "FooBar"}, true},
+		{map[string]string{"LA5NTA": "foobar", "LA5NTA-1": "FooBar"}, false}, // Wrong primary password
+		{map[string]string{"LA5NTA": "FOOBAR", "LA5NTA-1": "FOOBAR"}, false}, // Wrong auxiliary password
+		{map[string]string{"LA5NTA": "FOOBAR"}, false},                       // Missing auxiliary password
+	}
+
+	for i, test := range tests {
+		masterConn, clientConn := net.Pipe()
+
+		master := NewSession("N0CALL", "LA5NTA", "JO39EQ", nil)
+		master.SetLogger(discardLogger)
+		master.SetPasswordLookup(accounts)
+
+		passwords := test.passwords
+		client := NewSession("LA5NTA", "N0CALL", "JO39EQ", nil)
+		client.SetLogger(discardLogger)
+		client.AddAuxiliaryAddress(AddressFromString("LA5NTA-1"))
+		client.SetSecureLoginHandleFunc(func(addr Address) (string, error) { return passwords[addr.Addr], nil })
+
+		masterErr, clientErr := exchange(master, client, masterConn, clientConn)
+		switch {
+		case test.expectOK && (masterErr != nil || clientErr != nil):
+			t.Errorf("%d: Unexpected error: %v, %v", i, masterErr, clientErr)
+		case !test.expectOK && !errors.Is(masterErr, ErrSecureLoginFailed):
+			t.Errorf("%d: Expected master to refuse login, got %v", i, masterErr)
+		case !test.expectOK && !IsLoginFailure(clientErr):
+			t.Errorf("%d: Expected client to get login failure, got %v", i, clientErr)
+		}
+	}
+}
+
+func TestNewSecureChallenge(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		if c := newSecureChallenge(); len(c) != 8 || strings.Trim(c, "0123456789") != "" {
+			t.Fatalf("Invalid challenge '%s'", c)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Server.Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("fbb: Server closed")
+
+// A Server accepts incoming connections and exchanges messages with each connecting station in a session
+// of its own, with the local node as session master.
+//
+// The remote's call sign is taken from the connection's RemoteCall method if available (e.g. telnet),
+// or the remote address (e.g. ARDOP and AX.25).
+//
+// The fields should not be modified after calling Serve.
+type Server struct {
+	Mycall  string
+	Locator string
+
+	// Handler (required) returns the mailbox handler of the session with the given remote call sign.
+	//
+	// A non-nil error refuses the connection. Use AdaptMBoxHandler to return a MBoxHandler.
+	Handler func(remoteCall string) (MBoxHandlerV2, error)
+
+	// Configure (optional) is called before the exchange of each session, e.g. to enable verification
+	// of the remote's secure login with SetPasswordLookup.
+	//
+	// It returns the connection to exchange messages over, typically conn.
+	Configure func(s *Session, conn net.Conn) net.Conn
+
+	// MaxSessions is the max number of concurrent sessions (0 means no limit).
+	//
+	// Incoming connections are not accepted from the listener while the limit is reached.
+	MaxSessions int
+
+	// Timeout is the time allowed for each session (0 means no limit). Sessions exceeding the
+	// timeout are aborted (see Session.ExchangeContext).
+	Timeout time.Duration
+
+	// OnResult (optional) is called with the result of every session when it is done.
+	//
+	// It is called from the session's goroutine, so it must be safe for concurrent use.
+	OnResult func(SessionResult)
+
+	// Logger is the logger used by the server and its sessions (StdLogger if nil).
+	Logger *log.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	sem       chan struct{}      // Limits the number of concurrent sessions (see MaxSessions)
+	closed    chan struct{}      // Closed by Shutdown
+	ctx       context.Context    // The parent context of all sessions
+	abort     context.CancelFunc // Aborts all sessions
+	wg        sync.WaitGroup
+}
+
+// SessionResult is the result of a session with a connecting station.
+type SessionResult struct {
+	RemoteCall string
+	RemoteAddr net.Addr
+	Started    time.Time
+	Stats      TrafficStats
+	Err        error // The error returned by the exchange, or the reason the connection was refused
+}
+
+func (srv *Server) init() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closed != nil {
+		return
+	}
+	srv.listeners = make(map[net.Listener]bool)
+	srv.closed = make(chan struct{})
+	srv.ctx, srv.abort = context.WithCancel(context.Background())
+	if srv.MaxSessions > 0 {
+		srv.sem = make(chan struct{}, srv.MaxSessions)
+	}
+}
+
+// Serve accepts incoming connections on ln, serving each connection in a new goroutine.
+//
+// Serve always returns a non-nil error and closes ln. After Shutdown, the returned error is ErrServerClosed.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.init()
+
+	if srv.Handler == nil {
+		ln.Close()
+		return errors.New("fbb: Server.Handler is nil")
+	}
+
+	srv.mu.Lock()
+	select {
+	case <-srv.closed:
+		srv.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	default:
+		srv.listeners[ln] = true
+	}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.listeners, ln)
+		srv.mu.Unlock()
+		ln.Close()
+	}()
+
+	for {
+		if srv.sem != nil {
+			select {
+			case srv.sem <- struct{}{}:
+			case <-srv.closed:
+				return ErrServerClosed
+			}
+		}
+
+		conn, err := ln.Accept()
+		switch {
+		case err != nil && conn != nil:
+			conn.Close() // Accepted, but login failed (telnet)
+			srv.logger().Printf("Incoming connection failed: %s", err)
+			srv.release()
+			continue
+		case err != nil:
+			srv.release()
+			select {
+			case <-srv.closed:
+				return ErrServerClosed
+			default:
+				return err
+			}
+		}
+
+		// Make sure Shutdown is not waiting for the sessions before adding a new one
+		srv.mu.Lock()
+		select {
+		case <-srv.closed:
+			srv.mu.Unlock()
+			conn.Close()
+			srv.release()
+			return ErrServerClosed
+		default:
+			srv.wg.Add(1)
+		}
+		srv.mu.Unlock()
+
+		go func() {
+			defer srv.wg.Done()
+			defer srv.release()
+			srv.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown gracefully shuts down the server by closing all listeners and waiting for the active sessions to complete.
+//
+// If ctx is done before the sessions are complete, the active sessions are aborted (see Session.ExchangeContext)
+// and ctx's error is returned when they have returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.init()
+
+	srv.mu.Lock()
+	select {
+	case <-srv.closed:
+	default:
+		close(srv.closed)
+	}
+	for ln := range srv.listeners {
+		ln.Close()
+	}
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() { srv.wg.Wait(); close(done) }()
+
+	defer srv.abort()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	srv.abort()
+	<-done
+	return ctx.Err()
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	result := SessionResult{
+		RemoteCall: remoteCall(conn),
+		RemoteAddr: conn.RemoteAddr(),
+		Started:    time.Now(),
+	}
+	defer func() {
+		if result.Err != nil {
+			srv.logger().Printf("Session with %s failed: %s", result.RemoteCall, result.Err)
+		}
+		if srv.OnResult != nil {
+			srv.OnResult(result)
+		}
+	}()
+
+	if result.RemoteCall == "" {
+		conn.Close()
+		result.Err = errors.New("Unknown remote call sign")
+		return
+	}
+
+	h, err := srv.Handler(result.RemoteCall)
+	if err != nil {
+		srv.refuse(conn, err)
+		result.Err = err
+		return
+	}
+
+	ctx := srv.ctx
+	if srv.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.Timeout)
+		defer cancel()
+	}
+
+	s := NewSessionV2(srv.Mycall, result.RemoteCall, srv.Locator, h)
+	s.SetLogger(srv.logger())
+	s.IsMaster(true)
+	if srv.Configure != nil {
+		conn = srv.Configure(s, conn)
+	}
+
+	srv.logger().Printf("Session with %s started", result.RemoteCall)
+	result.Stats, result.Err = s.ExchangeContext(ctx, conn)
+}
+
+// refuse writes the reason the connection is refused to the remote, and closes conn.
+//
+// The write is given a deadline, and conn is closed if the sessions are aborted (see Shutdown),
+// so that a stalled link (or a transport ignoring deadlines) does not block the goroutine.
+func (srv *Server) refuse(conn net.Conn, reason error) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-srv.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	fmt.Fprintf(conn, "*** %s\r\n", reason)
+	close(done)
+	conn.Close()
+}
+
+func (srv *Server) release() {
+	if srv.sem != nil {
+		<-srv.sem
+	}
+}
+
+func (srv *Server) logger() *log.Logger {
+	if srv.Logger == nil {
+		return StdLogger
+	}
+	return srv.Logger
+}
+
+// remoteCall returns the call sign of the remote station connected to conn.
+func remoteCall(conn net.Conn) string {
+	if c, ok := conn.(interface{ RemoteCall() string }); ok {
+		return strings.ToUpper(c.RemoteCall())
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		return strings.ToUpper(addr.String())
+	}
+	return ""
+}
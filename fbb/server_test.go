@@ -0,0 +1,239 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport/telnet"
+)
+
+// resultRecorder records the results of a Server's sessions.
+type resultRecorder struct {
+	mu      sync.Mutex
+	results map[string]SessionResult
+}
+
+func (r *resultRecorder) record(result SessionResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.results == nil {
+		r.results = make(map[string]SessionResult)
+	}
+	r.results[result.RemoteCall] = result
+}
+
+func (r *resultRecorder) get(call string) (SessionResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, ok := r.results[call]
+	return result, ok
+}
+
+func TestServer(t *testing.T) {
+	ln, err := telnet.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		results resultRecorder
+		mu      sync.Mutex
+		mboxes  = make(map[string]*memMBox)
+	)
+	srv := &Server{
+		Mycall: "LA5NTA",
+		Handler: func(call string) (MBoxHandlerV2, error) {
+			if call == "N0BAD" {
+				return nil, errors.New("Unknown station")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			mboxes[call] = newMemMBox()
+			return AdaptMBoxHandler(mboxes[call]), nil
+		},
+		OnResult: results.record,
+		Logger:   discardLogger,
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	// Connect multiple stations concurrently
+	calls := []string{"N0CALL", "N1CALL", "N2CALL"}
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		wg.Add(1)
+		go func(call string) {
+			defer wg.Done()
+			conn, err := telnet.Dial(ln.Addr().String(), call, "")
+			if err != nil {
+				t.Errorf("%s: Dial failed: %s", call, err)
+				return
+			}
+			s := NewSession(call, "LA5NTA", "JO39EQ", newMemMBox(testMessage(call, "LA5NTA", 100)))
+			s.SetLogger(discardLogger)
+			if _, err := s.Exchange(conn); err != nil {
+				t.Errorf("%s: Exchange failed: %s", call, err)
+			}
+		}(call)
+	}
+	wg.Wait()
+
+	// A refused station
+	if conn, err := telnet.Dial(ln.Addr().String(), "N0BAD", ""); err == nil {
+		s := NewSession("N0BAD", "LA5NTA", "JO39EQ", nil)
+		s.SetLogger(discardLogger)
+		s.Exchange(conn)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %s", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Expected ErrServerClosed from Serve, got %v", err)
+	}
+
+	for _, call := range calls {
+		result, ok := results.get(call)
+		switch {
+		case !ok:
+			t.Errorf("%s: Missing result", call)
+		case result.Err != nil:
+			t.Errorf("%s: Unexpected error %s", call, result.Err)
+		case len(result.Stats.Received) != 1 || len(mboxes[call].in) != 1:
+			t.Errorf("%s: Expected one received message, got %v", call, result.Stats.Received)
+		}
+	}
+	if result, _ := results.get("N0BAD"); result.Err == nil {
+		t.Errorf("Expected N0BAD to be refused")
+	}
+}
+
+func TestServerShutdownAbort(t *testing.T) {
+	ln, err := telnet.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		results  resultRecorder
+		mu       sync.Mutex
+		sessions int
+	)
+	srv := &Server{
+		Mycall:      "LA5NTA",
+		MaxSessions: 1,
+		Handler: func(call string) (MBoxHandlerV2, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sessions++
+			return AdaptMBoxHandler(newMemMBox()), nil
+		},
+		OnResult: results.record,
+		Logger:   discardLogger,
+	}
+	go srv.Serve(ln)
+
+	// A station connecting, but never completing the handshake
+	conn, err := telnet.Dial(ln.Addr().String(), "N0CALL", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The login of a second station is blocked until the listener is closed
+	go func() {
+		if conn, err := telnet.Dial(ln.Addr().String(), "N1CALL", ""); err == nil {
+			conn.Close()
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if sessions != 1 {
+		t.Errorf("Expected 1 concurrent session, got %d", sessions)
+	}
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected shutdown deadline to be exceeded, got %v", err)
+	}
+
+	var abortErr *AbortError
+	if result, _ := results.get("N0CALL"); !errors.As(result.Err, &abortErr) {
+		t.Errorf("Expected session to be aborted, got %v", result.Err)
+	}
+}
+
+// stalledConn is a connection to a station that never reads, ignoring deadlines (like some radio transports).
+type stalledConn struct{ net.Conn }
+
+func (c stalledConn) RemoteCall() string                 { return "N0BAD" }
+func (c stalledConn) SetDeadline(t time.Time) error      { return nil }
+func (c stalledConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// connListener is a net.Listener accepting the connections sent on the channel.
+type connListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (ln *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (ln *connListener) Close() error   { ln.once.Do(func() { close(ln.closed) }); return nil }
+func (ln *connListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestServerShutdownStalledRefusal(t *testing.T) {
+	ln := &connListener{conns: make(chan net.Conn, 1), closed: make(chan struct{})}
+	srv := &Server{
+		Mycall:  "LA5NTA",
+		Handler: func(call string) (MBoxHandlerV2, error) { return nil, errors.New("Unknown station") },
+		Logger:  discardLogger,
+	}
+	go srv.Serve(ln)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	ln.conns <- stalledConn{local}
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected shutdown deadline to be exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown blocked by a refused connection")
+	}
+}
+
+func TestServerNilHandler(t *testing.T) {
+	ln := &connListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+	srv := &Server{Mycall: "LA5NTA", Logger: discardLogger}
+	if err := srv.Serve(ln); err == nil || err == ErrServerClosed {
+		t.Errorf("Expected error from Serve without Handler, got %v", err)
+	}
+}
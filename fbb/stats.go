@@ -0,0 +1,138 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"io"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// MessageStats holds transfer statistics for a single message.
+type MessageStats struct {
+	MID      string
+	Outbound bool
+	Codec    string // Name of the compression codec (empty if the message was not compressed)
+
+	Size           int // Uncompressed size
+	CompressedSize int // Compressed size
+	Transferred    int // Number of bytes transferred (less than CompressedSize if the transfer was resumed)
+
+	Started  time.Time     // When the transfer started
+	Duration time.Duration // The on-air duration of the transfer
+}
+
+// BytesPerMinute returns the throughput of the transfer, in (compressed) bytes per minute.
+func (m MessageStats) BytesPerMinute() float64 {
+	if m.Duration <= 0 {
+		return 0
+	}
+	return float64(m.Transferred) / m.Duration.Minutes()
+}
+
+func newMessageStats(p *Proposal, outbound bool, started, ended time.Time) MessageStats {
+	return MessageStats{
+		MID:            p.MID(),
+		Outbound:       outbound,
+		Codec:          p.codecName(),
+		Size:           p.size,
+		CompressedSize: p.compressedSize,
+		Transferred:    p.transferSize(),
+		Started:        started,
+		Duration:       ended.Sub(started),
+	}
+}
+
+// codecName returns the name of the codec used to compress the proposed message, or an empty string if not compressed.
+func (p *Proposal) codecName() string {
+	switch p.code {
+	case BasicProposal:
+		return ""
+	case AsciiProposal:
+		return LZHUFCodec.Name
+	default:
+		return lookupCodec(p.code).Name
+	}
+}
+
+// txTracker keeps track of outbound transfers to determine when each message has actually been
+// transmitted, taking into account the transport's tx buffer if available (see transport.TxBuffer).
+//
+// Only message data is counted, so the estimate relies on nothing else being written to the
+// connection during the transfer of an outbound block.
+type txTracker struct {
+	buf     transport.TxBuffer // Nil if not provided by the transport
+	written int                // The number of message bytes written during this block
+	lastEnd time.Time          // When the last finished transfer was done transmitting
+	pending []pendingTx        // Written, but possibly still in the tx buffer
+	done    []MessageStats     // Transmitted, but not yet confirmed by the remote
+}
+
+type pendingTx struct {
+	stats MessageStats
+	until int // The value of written when the whole message had been written
+}
+
+// reset prepares the tracker for a new block of outbound transfers over w.
+func (t *txTracker) reset(w io.Writer) {
+	t.buf, _ = w.(transport.TxBuffer)
+	t.written, t.lastEnd, t.pending, t.done = 0, time.Time{}, nil, nil
+}
+
+// writer returns a writer counting the message bytes written to w.
+func (t *txTracker) writer(w io.Writer) io.Writer { return txCounter{w, t} }
+
+type txCounter struct {
+	io.Writer
+	t *txTracker
+}
+
+func (c txCounter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.t.written += n
+	return n, err
+}
+
+// add adds the (fully written) transfer of the given proposal, started at the given time.
+func (t *txTracker) add(p *Proposal, started time.Time) {
+	t.pending = append(t.pending, pendingTx{newMessageStats(p, true, started, started), t.written})
+	t.poll()
+}
+
+// poll marks the pending transfers that has left the tx buffer as done.
+func (t *txTracker) poll() {
+	transmitted := t.written
+	if t.buf != nil {
+		transmitted -= t.buf.TxBufferLen()
+	}
+	for len(t.pending) > 0 && t.pending[0].until <= transmitted {
+		t.finish(t.pending[0].stats, time.Now())
+		t.pending = t.pending[1:]
+	}
+}
+
+// confirm marks every pending transfer as done, and returns the stats of every transfer since last reset.
+//
+// It is called when the remote has confirmed the transfer of the block.
+func (t *txTracker) confirm() []MessageStats {
+	now := time.Now()
+	for _, p := range t.pending {
+		t.finish(p.stats, now)
+	}
+	done := t.done
+	t.reset(nil)
+	return done
+}
+
+func (t *txTracker) finish(stats MessageStats, ended time.Time) {
+	// The transfer could not go on air before the previous one was done
+	if stats.Started.Before(t.lastEnd) {
+		stats.Started = t.lastEnd
+	}
+	stats.Duration = ended.Sub(stats.Started)
+	t.lastEnd = ended
+	t.done = append(t.done, stats)
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTrafficStats(t *testing.T) {
+	out, in := testMessage("LA5NTA", "LA1B", 1000), testMessage("LA1B", "LA5NTA", 2000)
+
+	clientConn, masterConn := net.Pipe()
+	master := NewSession("LA1B", "LA5NTA", "JO39EQ", newMemMBox(in))
+	master.SetLogger(discardLogger)
+	master.IsMaster(true)
+	client := NewSession("LA5NTA", "LA1B", "JO39EQ", newMemMBox(out))
+	client.SetLogger(discardLogger)
+
+	errs := make(chan error, 1)
+	go func() { _, err := master.Exchange(masterConn); errs <- err }()
+	stats, err := client.Exchange(clientConn)
+	if err != nil {
+		t.Fatalf("Client exchange failed: %s", err)
+	} else if err := <-errs; err != nil {
+		t.Fatalf("Master exchange failed: %s", err)
+	}
+
+	if len(stats.Messages) != 2 {
+		t.Fatalf("Expected stats for 2 messages, got %d", len(stats.Messages))
+	}
+	for i, msg := range []*Message{out, in} {
+		got := stats.Messages[i]
+		prop, _ := msg.Proposal(Wl2kProposal)
+		switch {
+		case got.MID != msg.MID() || got.Outbound != (msg == out):
+			t.Errorf("%d: Unexpected message %s (outbound=%t)", i, got.MID, got.Outbound)
+		case got.Codec != "lzhuf":
+			t.Errorf("%d: Unexpected codec %q", i, got.Codec)
+		case got.Size != prop.size || got.CompressedSize != prop.compressedSize || got.Transferred != prop.compressedSize:
+			t.Errorf("%d: Unexpected sizes %+v", i, got)
+		case got.Started.IsZero() || got.Duration <= 0 || got.BytesPerMinute() <= 0:
+			t.Errorf("%d: Unexpected timing %+v", i, got)
+		}
+	}
+
+	switch {
+	case stats.Handshake <= 0 || stats.Connected < stats.Handshake:
+		t.Errorf("Unexpected session timing (handshake %s, connected %s)", stats.Handshake, stats.Connected)
+	case stats.IdleTurnovers != 1: // The client has no more messages after receiving the master's message
+		t.Errorf("Expected 1 idle turnover, got %d", stats.IdleTurnovers)
+	}
+}
+
+type txBuffer struct {
+	bytes.Buffer
+	pending int
+}
+
+func (b *txBuffer) TxBufferLen() int { return b.pending }
+
+func TestTxTracker(t *testing.T) {
+	p1, _ := testMessage("LA5NTA", "LA1B", 100).Proposal(Wl2kProposal)
+	p2, _ := testMessage("LA5NTA", "LA1B", 100).Proposal(Wl2kProposal)
+
+	var (
+		tx  txTracker
+		buf txBuffer
+	)
+	tx.reset(&buf)
+
+	// Both messages are written at once, but stays in the tx buffer
+	started := time.Now()
+	tx.writer(&buf).Write(make([]byte, 100))
+	buf.pending = 100
+	tx.add(p1, started)
+	tx.writer(&buf).Write(make([]byte, 100))
+	buf.pending = 200
+	tx.add(p2, started)
+	if len(tx.done) != 0 {
+		t.Fatalf("Expected no transmitted messages, got %d", len(tx.done))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	buf.pending = 100 // The first message has been transmitted
+	tx.poll()
+	if len(tx.done) != 1 || tx.done[0].MID != p1.MID() {
+		t.Fatalf("Expected the first message to be transmitted, got %+v", tx.done)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	done := tx.confirm()
+	switch {
+	case len(done) != 2:
+		t.Fatalf("Expected two transmitted messages, got %d", len(done))
+	case !done[1].Started.Equal(done[0].Started.Add(done[0].Duration)):
+		t.Errorf("Expected the second transfer to start when the first was done")
+	case done[0].Duration < 10*time.Millisecond || done[1].Duration < 10*time.Millisecond:
+		t.Errorf("Unexpected durations %s, %s", done[0].Duration, done[1].Duration)
+	}
+}
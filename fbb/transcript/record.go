@@ -0,0 +1,75 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transcript
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Recorder is a net.Conn that records all data read from and written to the underlying connection.
+//
+// Entries are written to the transcript writer as they happen, so that the transcript is
+// available even if the exchange does not terminate gracefully.
+//
+// The optional transport interfaces of the wrapped connection (e.g. transport.Flusher) are not
+// exposed by the Recorder.
+type Recorder struct {
+	net.Conn
+
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+// Record returns a Recorder wrapping conn, writing the transcript to w.
+//
+// The comments are written to the head of the transcript.
+func Record(conn net.Conn, w io.Writer, comments ...string) *Recorder {
+	r := &Recorder{Conn: conn, w: w, start: time.Now()}
+	for _, c := range comments {
+		if _, err := writeComment(w, c); err != nil {
+			r.err = err
+			break
+		}
+	}
+	return r
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		r.record(Read, p[:n])
+	}
+	return n, err
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	n, err := r.Conn.Write(p)
+	if n > 0 {
+		r.record(Write, p[:n])
+	}
+	return n, err
+}
+
+// Err returns the first error encountered when writing the transcript (if any).
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(dir Direction, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	_, r.err = writeEntry(r.w, Entry{Offset: time.Since(r.start), Dir: dir, Data: data})
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transcript
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when reading from or writing to a closed Replayer.
+var ErrClosed = errors.New("use of closed network connection")
+
+// MismatchError is returned when the data written to a Replayer differs from the transcript.
+type MismatchError struct {
+	Entry    int    // Index of the transcript entry where the mismatch occurred.
+	Expected []byte // The (remaining) data expected by the transcript at this point, nil if a read was expected.
+	Got      []byte // The data written, nil if the mismatch was caused by a read.
+}
+
+func (e *MismatchError) Error() string {
+	switch {
+	case e.Got == nil:
+		return fmt.Sprintf("Transcript mismatch at entry %d: Got read while expecting write of %q", e.Entry, e.Expected)
+	case e.Expected == nil:
+		return fmt.Sprintf("Transcript mismatch at entry %d: Got write of %q while expecting read", e.Entry, e.Got)
+	default:
+		return fmt.Sprintf("Transcript mismatch at entry %d: Got write of %q, expected %q", e.Entry, e.Got, e.Expected)
+	}
+}
+
+// Replayer is a net.Conn playing back the remote side of a transcript.
+//
+// Reads return the data read in the transcript, and writes are verified against the data
+// written in the transcript. Data is made available for reading only after all preceding writes
+// in the transcript has been made, so the replay is deterministic. Timing is not replayed.
+//
+// How the data is chunked by the individual reads and writes does not matter, only the order
+// and content of the data in each direction. When all entries are consumed, Read returns io.EOF.
+type Replayer struct {
+	mu      sync.Mutex
+	entries []Entry
+	idx     int // The current entry
+	pos     int // Position in the current entry
+	err     error
+	closed  bool
+}
+
+// Replay returns a Replayer playing back the remote side of t.
+//
+// Use Transcript.Reverse to play back the local side instead.
+func Replay(t *Transcript) *Replayer {
+	// Merge adjacent entries of the same direction
+	var entries []Entry
+	for _, e := range t.Entries {
+		if n := len(entries); n > 0 && entries[n-1].Dir == e.Dir {
+			entries[n-1].Data = append(entries[n-1].Data, e.Data...)
+			continue
+		}
+		e.Data = append([]byte(nil), e.Data...)
+		entries = append(entries, e)
+	}
+	return &Replayer{entries: entries}
+}
+
+func (r *Replayer) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.closed:
+		return 0, ErrClosed
+	case r.err != nil:
+		return 0, r.err
+	case r.idx >= len(r.entries):
+		return 0, io.EOF
+	}
+
+	e := r.entries[r.idx]
+	if e.Dir != Read {
+		r.err = &MismatchError{Entry: r.idx, Expected: e.Data[r.pos:]}
+		return 0, r.err
+	}
+
+	n := copy(p, e.Data[r.pos:])
+	r.advance(n)
+	return n, nil
+}
+
+func (r *Replayer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.closed:
+		return 0, ErrClosed
+	case r.err != nil:
+		return 0, r.err
+	}
+
+	var n int
+	for n < len(p) {
+		if r.idx >= len(r.entries) || r.entries[r.idx].Dir != Write {
+			r.err = &MismatchError{Entry: r.idx, Got: p[n:]}
+			return n, r.err
+		}
+
+		expected := r.entries[r.idx].Data[r.pos:]
+		m := len(p) - n
+		if m > len(expected) {
+			m = len(expected)
+		}
+		if !bytes.Equal(p[n:n+m], expected[:m]) {
+			r.err = &MismatchError{Entry: r.idx, Expected: expected, Got: p[n:]}
+			return n, r.err
+		}
+		n += m
+		r.advance(m)
+	}
+	return n, nil
+}
+
+func (r *Replayer) advance(n int) {
+	r.pos += n
+	if r.pos == len(r.entries[r.idx].Data) {
+		r.idx, r.pos = r.idx+1, 0
+	}
+}
+
+// Err returns the first mismatch between the transcript and the data written (if any).
+func (r *Replayer) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Done returns true if the whole transcript has been played back.
+func (r *Replayer) Done() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.idx >= len(r.entries)
+}
+
+// Close closes the connection.
+func (r *Replayer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *Replayer) LocalAddr() net.Addr                { return addr("local") }
+func (r *Replayer) RemoteAddr() net.Addr               { return addr("remote") }
+func (r *Replayer) SetDeadline(t time.Time) error      { return nil }
+func (r *Replayer) SetReadDeadline(t time.Time) error  { return nil }
+func (r *Replayer) SetWriteDeadline(t time.Time) error { return nil }
+
+type addr string
+
+func (a addr) Network() string { return "transcript" }
+func (a addr) String() string  { return string(a) }
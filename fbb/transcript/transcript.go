@@ -0,0 +1,194 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package transcript provides recording and deterministic replay of the raw data
+// exchanged over a connection during a B2F exchange.
+//
+// It is intended for debugging interoperability problems with other implementations
+// (RMS Express, Paclink, CMS) and for regression testing of protocol quirks.
+//
+// A transcript is a line oriented text file. Each entry holds the time since the recording
+// started (in seconds), the direction of the data (< for data read from the remote, > for data
+// written to the remote) and the data as a quoted Go string:
+//
+//	# Recorded by N0CALL
+//	0.000 < "[RMS Express-1.5.2.0-B2FHM$]\r"
+//	0.012 > ";FW: N0CALL\r[wl2kgo-0.1a-B2FHM$]\r"
+//
+// Lines starting with # are comments.
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Direction is the direction of the data in a transcript entry.
+type Direction byte
+
+const (
+	Read  Direction = '<' // Data read from the remote.
+	Write Direction = '>' // Data written to the remote.
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry is a chunk of data read or written at a given time.
+type Entry struct {
+	Offset time.Duration // The time since the recording started.
+	Dir    Direction
+	Data   []byte
+}
+
+// Transcript holds the recorded data exchanged over a connection.
+type Transcript struct {
+	Comments []string // Comment lines (without the # prefix).
+	Entries  []Entry
+}
+
+// Load reads and parses the transcript file at the given path.
+func Load(path string) (*Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse parses a transcript from r.
+func Parse(r io.Reader) (*Transcript, error) {
+	t := new(Transcript)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case line[0] == '#':
+			t.Comments = append(t.Comments, strings.TrimSpace(line[1:]))
+			continue
+		}
+
+		e, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+		t.Entries = append(t.Entries, e)
+	}
+
+	return t, scanner.Err()
+}
+
+func parseEntry(line string) (e Entry, err error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return e, errors.New("Malformed entry")
+	}
+
+	secs, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return e, fmt.Errorf("Malformed offset: %s", parts[0])
+	}
+	e.Offset = time.Duration(secs * float64(time.Second))
+
+	switch dir := Direction(parts[1][0]); {
+	case len(parts[1]) != 1:
+		return e, fmt.Errorf("Malformed direction: %s", parts[1])
+	case dir == Read, dir == Write:
+		e.Dir = dir
+	default:
+		return e, fmt.Errorf("Unknown direction: %s", parts[1])
+	}
+
+	data, err := strconv.Unquote(parts[2])
+	if err != nil {
+		return e, fmt.Errorf("Malformed data: %s", err)
+	}
+	e.Data = []byte(data)
+
+	return e, nil
+}
+
+// WriteTo writes the transcript to w.
+func (t *Transcript) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, c := range t.Comments {
+		m, err := writeComment(w, c)
+		if n += int64(m); err != nil {
+			return n, err
+		}
+	}
+	for _, e := range t.Entries {
+		m, err := writeEntry(w, e)
+		if n += int64(m); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Reverse returns a copy of the transcript as seen from the remote side of the connection.
+func (t *Transcript) Reverse() *Transcript {
+	r := &Transcript{
+		Comments: append([]string(nil), t.Comments...),
+		Entries:  make([]Entry, len(t.Entries)),
+	}
+	for i, e := range t.Entries {
+		e.Dir = Read + Write - e.Dir
+		r.Entries[i] = e
+	}
+	return r
+}
+
+func writeComment(w io.Writer, comment string) (int, error) {
+	return fmt.Fprintf(w, "# %s\n", comment)
+}
+
+func writeEntry(w io.Writer, e Entry) (int, error) {
+	return fmt.Fprintf(w, "%.3f %c %s\n", e.Offset.Seconds(), e.Dir, quote(e.Data))
+}
+
+// quote returns a double-quoted Go string literal representing data, escaping every byte
+// that is not printable ASCII.
+func quote(data []byte) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range data {
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c >= 0x20 && c < 0x7f:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
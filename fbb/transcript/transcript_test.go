@@ -0,0 +1,137 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transcript
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundtrip(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	expect := &Transcript{
+		Comments: []string{"Test"},
+		Entries: []Entry{
+			{Offset: 0, Dir: Read, Data: []byte("[WL2K-5.0-B2FWIHJM$]\r")},
+			{Offset: 1500 * time.Millisecond, Dir: Write, Data: []byte("\"quoted\" \\ \n")},
+			{Offset: 2 * time.Second, Dir: Read, Data: all},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := expect.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %+v, expected %+v", got, expect)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, str := range []string{
+		`0.000 ? "data"`,
+		`0.000 < data`,
+		`abc < "data"`,
+		`0.000 <`,
+	} {
+		if _, err := Parse(bytes.NewBufferString(str)); err == nil {
+			t.Errorf("Expected error parsing %q", str)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	local, remote := net.Pipe()
+
+	var buf bytes.Buffer
+	rec := Record(local, &buf, "Test")
+	go func() {
+		remote.Write([]byte("Hello\r"))
+		io.ReadFull(remote, make([]byte, 4))
+		remote.Write([]byte("Bye\r"))
+		remote.Close()
+	}()
+
+	p := make([]byte, 6)
+	io.ReadFull(rec, p)
+	rec.Write([]byte("Hi\r\n"))
+	ioutil.ReadAll(rec)
+	rec.Close()
+
+	tr, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay the remote side, but split the writes differently
+	conn := Replay(tr)
+	if p, _ := ioutil.ReadAll(io.LimitReader(conn, 6)); string(p) != "Hello\r" {
+		t.Errorf("Unexpected data %q", p)
+	}
+	conn.Write([]byte("Hi"))
+	conn.Write([]byte("\r\n"))
+	if p, err := ioutil.ReadAll(conn); err != nil || string(p) != "Bye\r" {
+		t.Errorf("Unexpected data %q (%v)", p, err)
+	}
+	if err := conn.Err(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if !conn.Done() {
+		t.Errorf("Expected transcript to be done")
+	}
+
+	// Replay the local side
+	conn = Replay(tr.Reverse())
+	conn.Write([]byte("Hello\r"))
+	if p, _ := ioutil.ReadAll(io.LimitReader(conn, 4)); string(p) != "Hi\r\n" {
+		t.Errorf("Unexpected data %q", p)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	tr := &Transcript{Entries: []Entry{
+		{Dir: Write, Data: []byte("FF\r")},
+		{Dir: Read, Data: []byte("FQ\r")},
+	}}
+
+	// Unexpected data
+	conn := Replay(tr)
+	if _, err := conn.Write([]byte("FQ\r")); err == nil {
+		t.Errorf("Expected mismatch error")
+	} else if err, ok := err.(*MismatchError); !ok || err.Entry != 0 || string(err.Got) != "FQ\r" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 3)); err != conn.Err() {
+		t.Errorf("Expected error to be sticky, got %v", err)
+	}
+
+	// Read while write is expected
+	conn = Replay(tr)
+	if _, err := conn.Read(make([]byte, 3)); err == nil {
+		t.Errorf("Expected mismatch error")
+	}
+
+	// Write past end of transcript
+	conn = Replay(tr)
+	conn.Write([]byte("FF\r"))
+	conn.Read(make([]byte, 3))
+	if _, err := conn.Write([]byte("FQ\r")); err == nil {
+		t.Errorf("Expected mismatch error")
+	}
+}
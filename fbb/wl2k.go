@@ -9,12 +9,12 @@ package fbb
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"sort"
 	"strings"
 	"time"
 
@@ -76,26 +76,61 @@ type Session struct {
 	locator    string
 	motd       []string
 
-	h             MBoxHandler
+	h             MBoxHandlerV2
+	aborts        AbortHandler
+	acks          AckHandler
 	statusUpdater StatusUpdater
+	observer      EventObserver
+	partials      PartialStore
+	bids          BIDStore
+	forwardRules  ForwardRules // See SetForwardRules
+	ordering      OrderingPolicy
+	codecs        []PropCode // Enabled codecs, see SetCodecs
+	batchLimit    int        // See SetBatchLimit
+	qtc           bool       // See SetQTC
+	masterQTC     bool       // See SetMasterQTC
+
+	spoolDir       string // See SetSpool
+	spoolThreshold int    // See SetSpool
 
 	// Callback when secure login password is needed
-	secureLoginHandleFunc func() (password string, err error)
+	secureLoginHandleFunc func(addr Address) (password string, err error)
+
+	passwordLookup  PasswordLookup // Used to verify the remote's secure login (master only)
+	secureChallenge string         // The secure login challenge sent to the remote (master only)
 
 	master     bool
 	robustMode robustMode
+	proto      forwardProtocol // The negotiated forwarding protocol
+	writeSize  int             // Write size override (see SetWriteSize)
 
 	remoteSID sid
 	remoteFW  []Address // Addresses the remote requests messages on behalf of
+	remote    RemoteInfo
 	localFW   []Address // Addresses we request messages on behalf of
 
 	trafficStats TrafficStats
+	budget       Budget      // See SetBudget
+	used         budgetUsage // Traffic accounted against the budget
 
 	quitReceived bool
 	quitSent     bool
 	remoteNoMsgs bool // True if last remote turn had no more messages
 
-	rd *bufio.Reader
+	inFlight []string // MIDs of accepted messages not yet confirmed transferred
+
+	pendingAcks []*Message           // Acknowledgements to propose in our next turn
+	ackMIDs     map[string]bool      // MIDs of the acknowledgements generated in this session
+	outboundTo  map[string][]Address // Receivers of the proposed private messages, by MID
+	ackable     map[string][]Address // Receivers of the sent private messages, by MID
+
+	batches map[string][]string // MIDs of the messages in each outbound batch, by batch MID
+
+	tx        txTracker // Outbound transfers of the current block
+	rxStarted time.Time // When the current inbound transfer started
+
+	rd  *bufio.Reader
+	ctx context.Context // The context of the exchange
 
 	log  *log.Logger
 	pLog *log.Logger
@@ -129,6 +164,17 @@ type Status struct {
 type TrafficStats struct {
 	Received []string // Received message MIDs.
 	Sent     []string // Sent message MIDs.
+
+	// Messages left when the session budget was spent (see SetBudget).
+	OutboundLeft []string // MIDs of outbound messages not proposed.
+	InboundLeft  []string // MIDs of inbound messages deferred.
+
+	Messages []MessageStats // Transfer statistics of sent and received messages, in order of transfer.
+
+	// Session phases.
+	Handshake     time.Duration // Time spent on the handshake.
+	IdleTurnovers int           // Number of turnovers where the turn holder had no messages to transfer.
+	Connected     time.Duration // Total connected time.
 }
 
 var StdLogger = log.New(os.Stderr, "", log.LstdFlags)
@@ -140,22 +186,43 @@ var StdUA = UserAgent{Name: "wl2kgo", Version: "0.1a"}
 //
 // Mycall and targetcall will be upper-cased.
 func NewSession(mycall, targetcall, locator string, h MBoxHandler) *Session {
+	return NewSessionV2(mycall, targetcall, locator, AdaptMBoxHandler(h))
+}
+
+// NewSessionV2 is like NewSession, but takes a MBoxHandlerV2.
+//
+// Errors returned by the handler aborts the exchange, and are forwarded (if possible) to the remote.
+func NewSessionV2(mycall, targetcall, locator string, h MBoxHandlerV2) *Session {
 	mycall, targetcall = strings.ToUpper(mycall), strings.ToUpper(targetcall)
 
-	return &Session{
+	partials, _ := optionalHandler(h).(PartialStore)
+	bids, _ := optionalHandler(h).(BIDStore)
+	aborts, _ := optionalHandler(h).(AbortHandler)
+	acks, _ := optionalHandler(h).(AckHandler)
+
+	s := &Session{
+		ctx:        context.Background(),
 		mycall:     mycall,
 		localFW:    []Address{AddressFromString(mycall)},
 		targetcall: targetcall,
 		log:        StdLogger,
 		h:          h,
+		aborts:     aborts,
+		acks:       acks,
+		partials:   partials,
+		bids:       bids,
 		pLog:       StdLogger,
 		ua:         StdUA,
 		locator:    locator,
+
+		spoolThreshold: -1,
 		trafficStats: TrafficStats{
 			Received: make([]string, 0),
 			Sent:     make([]string, 0),
 		},
 	}
+	s.SetCodecs(CodecsFromEnv()...)
+	return s
 }
 
 type robustMode int
@@ -167,6 +234,16 @@ const (
 	RobustDisabled                   // Never run the connection in robust-mode.
 )
 
+// SetSpool enables spooling of large inbound attachments to disk.
+//
+// Attachments larger than threshold bytes are written to new files in dir (the default directory
+// for temporary files if empty) while the message is decompressed, instead of being held in memory
+// (see Message.ReadFromSpool). The files are removed when the MBoxHandler's ProcessInbound returns,
+// so the handler must write (see Message.Write) or copy the attachments before returning.
+//
+// A negative threshold disables spooling (default).
+func (s *Session) SetSpool(dir string, threshold int) { s.spoolDir, s.spoolThreshold = dir, threshold }
+
 // SetRobustMode sets the RobustMode for this exchange.
 //
 // The mode is ignored if the exchange connection does not implement the transport.Robust interface.
@@ -177,6 +254,24 @@ func (s *Session) SetRobustMode(mode robustMode) {
 	//TODO: If NewSession took the net.Conn (not Exchange), we could return an error here to indicate that the operation was unsupported.
 }
 
+// SetWriteSize overrides the maximum number of bytes per write to the connection when transferring messages.
+//
+// By default, the optimal write size advertised by the connection is used (see transport.WriteSizer).
+// If the connection does not advertise it, MaxMsgLength sized chunks (plus framing) are written.
+// A value <= 0 restores the default.
+func (s *Session) SetWriteSize(n int) { s.writeSize = n }
+
+// optimalWriteSize returns the maximum number of bytes per write to w.
+func (s *Session) optimalWriteSize(w io.Writer) int {
+	if s.writeSize > 0 {
+		return s.writeSize
+	}
+	if ws, ok := w.(transport.WriteSizer); ok && ws.OptimalWriteSize() > 0 {
+		return ws.OptimalWriteSize()
+	}
+	return MaxMsgLength + 2 // Chunk length + STX and length bytes
+}
+
 // SetMOTD sets one or more lines to be sent before handshake.
 //
 // The MOTD is only sent if the local node is session master.
@@ -206,6 +301,16 @@ func (s *Session) RemoteSID() string { return string(s.remoteSID) }
 //
 // Subsequent Exchange calls on the same session is a noop.
 func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
+	return s.ExchangeContext(context.Background(), conn)
+}
+
+// ExchangeContext is like Exchange, but aborts the exchange when the given context is done.
+//
+// When aborted, an abort line (***) is sent to the remote (if possible) before the connection
+// is closed and an *AbortError is returned. The error holds the MIDs of the messages that
+// were being transferred, and the mailbox handler is notified if it implements the
+// AbortHandler interface.
+func (s *Session) ExchangeContext(ctx context.Context, conn net.Conn) (stats TrafficStats, err error) {
 	if s.Done() {
 		return stats, nil
 	}
@@ -217,6 +322,11 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 			return
 		}
 
+		if ctx.Err() != nil {
+			err = s.abort(conn, ctx.Err())
+			return
+		}
+
 		// In case another go-routine closes the connection...
 		localEOF := strings.Contains(err.Error(), "use of closed network connection")
 		if localEOF {
@@ -224,6 +334,7 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 		}
 
 		if err != io.EOF {
+			s.event(Event{Type: EventError, Outbound: true, Line: fmt.Sprintf("*** %s", err), Err: err})
 			conn.SetDeadline(time.Now().Add(time.Minute))
 			fmt.Fprintf(conn, "*** %s\r\n", err)
 			conn.Close()
@@ -232,6 +343,13 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 		}
 	}()
 
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	defer watchContext(ctx, conn)()
+	s.ctx = ctx
+	s.used.started = time.Now()
+
 	// Prepare mailbox handler
 	if s.h != nil {
 		err = s.h.Prepare()
@@ -248,13 +366,15 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 
 	s.rd = bufio.NewReader(conn)
 
+	handshakeStarted := time.Now()
 	err = s.handshake(conn)
 	if err != nil {
 		return
 	}
+	s.trafficStats.Handshake = time.Since(handshakeStarted)
 
-	if gzipExperimentEnabled() && s.remoteSID.Has(sGzip) {
-		s.log.Println("GZIP_EXPERIMENT:", "Gzip compression enabled in this session.")
+	if codecs := s.remoteCodecs(); len(codecs) > 1 && s.proto == protoB2F {
+		s.log.Printf("Compression codecs enabled in this session: %s", codecNames(codecs))
 	}
 
 	for myTurn := !s.master; !s.Done(); myTurn = !myTurn {
@@ -265,11 +385,17 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 		}
 
 		if err != nil {
-			return s.trafficStats, err
+			return s.stats(), err
 		}
 	}
 
-	return s.trafficStats, conn.Close()
+	return s.stats(), conn.Close()
+}
+
+// stats returns the traffic stats of the exchange.
+func (s *Session) stats() TrafficStats {
+	s.trafficStats.Connected = time.Since(s.used.started)
+	return s.trafficStats
 }
 
 // Done() returns true if either parties have existed from this session.
@@ -297,12 +423,7 @@ func remoteErr(str string) error {
 		return nil
 	}
 
-	idx := strings.LastIndex(str, "*")
-	if idx+1 >= len(str) {
-		return nil
-	}
-
-	return fmt.Errorf(strings.TrimSpace(str[idx+1:]))
+	return errLine(str)
 }
 
 // Mycall returns this stations call sign.
@@ -312,7 +433,11 @@ func (s *Session) Mycall() string { return s.mycall }
 func (s *Session) Targetcall() string { return s.targetcall }
 
 // SetSecureLoginHandleFunc registers a callback function used to prompt for password when a secure login challenge is received.
-func (s *Session) SetSecureLoginHandleFunc(f func() (password string, err error)) {
+//
+// The callback is called once per forwarder address (see AddAuxiliaryAddress), starting with mycall.
+// The returned password is used to compute the address' individual secure login response. If the
+// password returned for an auxiliary address is empty, the address is requested without a response.
+func (s *Session) SetSecureLoginHandleFunc(f func(addr Address) (password string, err error)) {
 	s.secureLoginHandleFunc = f
 }
 
@@ -346,13 +471,18 @@ func (s *Session) SetUserAgent(ua UserAgent) { s.ua = ua }
 // Get this session's user agent
 func (s *Session) UserAgent() UserAgent { return s.ua }
 
-func (s *Session) outbound() []*Proposal {
+func (s *Session) outbound() ([]*Proposal, error) {
 	if s.h == nil {
-		return []*Proposal{}
+		return []*Proposal{}, nil
 	}
 
-	msgs := s.h.GetOutbound(s.remoteFW...)
+	msgs, err := s.h.GetOutbound(s.ctx, s.remoteFW...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get outbound messages: %w", err)
+	}
+	msgs = append(msgs, s.pendingAcks...)
 	props := make([]*Proposal, 0, len(msgs))
+	proposed := make([]*Message, 0, len(msgs)) // The message of each proposal
 
 	for _, m := range msgs {
 		// It seems reasonable to ignore these with a warning
@@ -360,36 +490,40 @@ func (s *Session) outbound() []*Proposal {
 			s.log.Printf("Ignoring invalid outbound message '%s': %s", m.MID(), err)
 			continue
 		}
+		if !s.forward(m) {
+			continue
+		}
+
+		if s.proto != protoB2F {
+			prop, err := m.fbbProposal(s.proto.propCode(), s.proto == protoFBBComp0)
+			if err != nil {
+				s.log.Printf("Unable to prepare FBB proposal for '%s': %s. Ignoring...", m.MID(), err)
+				continue
+			}
+			if prop.at == "" {
+				prop.at = s.targetcall
+			}
+			props, proposed = append(props, prop), append(proposed, m)
+			continue
+		}
 
-		prop, err := m.Proposal(s.highestPropCode())
+		// Propose the smallest of the message compressed with each codec supported by both parties
+		prop, err := smallestProposal(m, s.remoteCodecs())
 		if err != nil {
 			s.log.Printf("Unable to prepare proposal for '%s'. Corrupt message? Ignoring...", m.MID())
 			continue
 		}
 
-		props = append(props, prop)
+		props, proposed = append(props, prop), append(proposed, m)
 	}
 
-	// Sort the proposals by size, smallest first as suggested by the Winlink FAQ Q460.
-	sort.Sort(bySize(props))
-
-	return props
-}
+	for _, m := range proposed {
+		s.trackOutbound(m)
+	}
 
-type bySize []*Proposal
+	// Sort the proposals according to the ordering policy (by size, smallest first, by default).
+	s.sortOutbound(proposed, props)
 
-func (s bySize) Len() int      { return len(s) }
-func (s bySize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
-func (s bySize) Less(i, j int) bool {
-	if s[i].compressedSize != s[j].compressedSize {
-		return s[i].compressedSize < s[j].compressedSize
-	}
-	return s[i].MID() < s[j].MID()
+	return s.budgetOutbound(s.batchOutbound(proposed, props)), nil
 }
 
-func (s *Session) highestPropCode() PropCode {
-	if s.remoteSID.Has(sGzip) && gzipExperimentEnabled() {
-		return GzipProposal
-	}
-	return Wl2kProposal
-}
@@ -7,8 +7,12 @@ package fbb
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -187,3 +191,134 @@ func TestSessionCMSv4(t *testing.T) {
 		t.Errorf("Session exchange returned error: %s", err)
 	}
 }
+
+// memMBox is an in-memory MBoxHandler (and PartialStore) used for testing.
+type memMBox struct {
+	mu       sync.Mutex
+	out      map[string]*Message
+	in       map[string]*Message
+	partials map[string][]byte
+	sent     map[string]bool
+	deferred map[string]bool
+	aborted  []string
+}
+
+func newMemMBox(out ...*Message) *memMBox {
+	mbox := &memMBox{
+		out:      make(map[string]*Message),
+		in:       make(map[string]*Message),
+		partials: make(map[string][]byte),
+		sent:     make(map[string]bool),
+		deferred: make(map[string]bool),
+	}
+	for _, msg := range out {
+		mbox.out[msg.MID()] = msg
+	}
+	return mbox
+}
+
+func (m *memMBox) Prepare() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deferred = make(map[string]bool)
+	return nil
+}
+
+func (m *memMBox) GetOutbound(fw ...Address) []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs := make([]*Message, 0, len(m.out))
+	for mid, msg := range m.out {
+		if !m.deferred[mid] {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func (m *memMBox) SetSent(MID string, rejected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.out, MID)
+	m.sent[MID] = rejected
+}
+
+func (m *memMBox) SetDeferred(MID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deferred[MID] = true
+}
+
+func (m *memMBox) ProcessInbound(msgs ...*Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range msgs {
+		m.in[msg.MID()] = msg
+	}
+	return nil
+}
+
+func (m *memMBox) GetInboundAnswer(p Proposal) ProposalAnswer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.in[p.MID()]; ok {
+		return Reject
+	}
+	return Accept
+}
+
+func (m *memMBox) SetAborted(MIDs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aborted = append(m.aborted, MIDs...)
+}
+
+func (m *memMBox) GetPartial(MID string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.partials[MID]
+}
+
+func (m *memMBox) SetPartial(MID string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.partials[MID] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memMBox) DeletePartial(MID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.partials, MID)
+	return nil
+}
+
+// testMessage returns a new valid message with a pseudo-random body of the given size.
+func testMessage(from, to string, bodySize int) *Message {
+	rnd := rand.New(rand.NewSource(int64(bodySize)))
+	body := make([]byte, bodySize)
+	for i := range body {
+		body[i] = byte('!' + rnd.Intn('~'-'!'))
+	}
+
+	msg := NewMessage(Private, from)
+	msg.AddTo(to)
+	msg.SetSubject("Test message")
+	msg.SetBody(string(body))
+	return msg
+}
+
+// exchange runs a P2P exchange between the two given sessions over the given connections.
+func exchange(master, client *Session, masterConn, clientConn net.Conn) (masterErr, clientErr error) {
+	master.IsMaster(true)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Exchange(clientConn)
+		errs <- err
+	}()
+	_, masterErr = master.Exchange(masterConn)
+	return masterErr, <-errs
+}
+
+var discardLogger = log.New(ioutil.Discard, "", 0)
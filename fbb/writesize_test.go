@@ -0,0 +1,72 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"net"
+	"testing"
+)
+
+// sizedConn advertises an optimal write size and records the length of every write.
+type sizedConn struct {
+	net.Conn
+	size   int
+	writes []int
+}
+
+func (c *sizedConn) OptimalWriteSize() int { return c.size }
+
+func (c *sizedConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return c.Conn.Write(p)
+}
+
+func (c *sizedConn) maxWrite() (max int) {
+	for _, n := range c.writes {
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func TestOptimalWriteSize(t *testing.T) {
+	tests := []struct {
+		advertised, override, expectMax int
+	}{
+		{advertised: 0, expectMax: MaxMsgLength + 2},
+		{advertised: 64, expectMax: 64},
+		{advertised: 1024, expectMax: 1024},
+		{advertised: 1024, override: 100, expectMax: 100},
+	}
+
+	for _, test := range tests {
+		msg := testMessage("N0CALL", "LA5NTA", 5000)
+		receiver := newMemMBox()
+
+		masterConn, clientConn := net.Pipe()
+		conn := &sizedConn{Conn: clientConn, size: test.advertised}
+
+		master := NewSession("LA5NTA", "N0CALL", "JO39EQ", receiver)
+		master.SetLogger(discardLogger)
+		client := NewSession("N0CALL", "LA5NTA", "JO39EQ", newMemMBox(msg))
+		client.SetLogger(discardLogger)
+		client.SetWriteSize(test.override)
+
+		masterErr, clientErr := exchange(master, client, masterConn, conn)
+		if masterErr != nil || clientErr != nil {
+			t.Fatalf("%+v: Exchange failed: %v, %v", test, masterErr, clientErr)
+		}
+		if _, ok := receiver.in[msg.MID()]; !ok {
+			t.Fatalf("%+v: Message not delivered", test)
+		}
+		if max := conn.maxWrite(); max > test.expectMax {
+			t.Errorf("%+v: Got write of %d bytes, expected max %d", test, max, test.expectMax)
+		}
+		if test.expectMax > 250 && conn.maxWrite() <= 252 {
+			t.Errorf("%+v: Expected multiple blocks per write, largest write was %d bytes", test, conn.maxWrite())
+		}
+	}
+}
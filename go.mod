@@ -1,6 +1,6 @@
 module github.com/la5nta/wl2k-go
 
-go 1.11
+go 1.13
 
 require (
 	github.com/paulrosania/go-charset v0.0.0-20151028000031-621bb39fcc83
@@ -0,0 +1,157 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package hub
+
+import (
+	"context"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/mailbox"
+)
+
+// stationHandler is the fbb.MBoxHandlerV2 used in a session with a local station.
+type stationHandler struct {
+	hub  *Hub
+	call string
+
+	mbox    *mailbox.DirHandler            // The station's mailbox
+	fwMBox  map[string]*mailbox.DirHandler // The mailboxes of the station's forwarder addresses
+	offered map[string]*mailbox.DirHandler // The mailbox of each offered message (by MID)
+}
+
+func (h *stationHandler) Prepare() error {
+	mbox, err := h.hub.mailbox(h.call)
+	if err != nil {
+		return err
+	}
+	h.mbox = mbox
+	h.fwMBox = map[string]*mailbox.DirHandler{h.call: h.mbox}
+	h.offered = make(map[string]*mailbox.DirHandler)
+	return nil
+}
+
+// GetOutbound returns the messages waiting in the mailboxes of the station's forwarder addresses.
+func (h *stationHandler) GetOutbound(ctx context.Context, fw ...fbb.Address) ([]*fbb.Message, error) {
+	if len(fw) == 0 {
+		fw = []fbb.Address{fbb.AddressFromString(h.call)}
+	}
+
+	var out []*fbb.Message
+	for _, addr := range fw {
+		if addr.Proto != "" || addr.Addr == h.hub.mycall || !h.hub.IsLocal(addr.Addr) {
+			continue
+		}
+
+		mbox, ok := h.fwMBox[addr.Addr]
+		if !ok {
+			var err error
+			if mbox, err = h.hub.mailbox(addr.Addr); err != nil {
+				h.hub.log.Printf("Unable to prepare mailbox of %s: %s", addr.Addr, err)
+				continue
+			}
+			h.fwMBox[addr.Addr] = mbox
+		}
+
+		// All messages in the mailbox are to be delivered to the station (no forwarder addresses given)
+		msgs, err := mbox.V2().GetOutbound(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			h.offered[msg.MID()] = mbox
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+func (h *stationHandler) SetSent(ctx context.Context, MID string, rejected bool) error {
+	if mbox, ok := h.offered[MID]; ok {
+		return mbox.V2().SetSent(ctx, MID, rejected)
+	}
+	return nil
+}
+
+func (h *stationHandler) SetDeferred(ctx context.Context, MID string) error {
+	if mbox, ok := h.offered[MID]; ok {
+		return mbox.V2().SetDeferred(ctx, MID)
+	}
+	return nil
+}
+
+// ProcessInbound stores the messages in the station's mailbox and routes them to their recipients.
+func (h *stationHandler) ProcessInbound(msgs ...*fbb.Message) error {
+	if err := h.mbox.ProcessInbound(msgs...); err != nil {
+		return err
+	}
+	return h.hub.Route(msgs...)
+}
+
+func (h *stationHandler) GetInboundAnswer(p fbb.Proposal) fbb.ProposalAnswer {
+	if h.hub.hasMessage(p.MID()) {
+		return fbb.Reject
+	}
+	return fbb.Accept
+}
+
+// Partially received messages are stored in the station's mailbox.
+func (h *stationHandler) GetPartial(MID string) []byte {
+	return h.mbox.GetPartial(MID)
+}
+
+func (h *stationHandler) SetPartial(MID string, data []byte) error {
+	return h.mbox.SetPartial(MID, data)
+}
+
+func (h *stationHandler) DeletePartial(MID string) error {
+	return h.mbox.DeletePartial(MID)
+}
+
+// upstreamHandler is the fbb.MBoxHandlerV2 used in sessions with the upstream CMS.
+type upstreamHandler struct {
+	hub  *Hub
+	mbox *mailbox.DirHandler // The hub's mailbox
+}
+
+func (h *upstreamHandler) Prepare() error {
+	h.mbox = mailbox.NewDirHandler(mailbox.UserPath(h.hub.root, h.hub.mycall), false)
+	return h.mbox.Prepare()
+}
+
+func (h *upstreamHandler) GetOutbound(ctx context.Context, fw ...fbb.Address) ([]*fbb.Message, error) {
+	return h.mbox.V2().GetOutbound(ctx, fw...)
+}
+
+func (h *upstreamHandler) SetSent(ctx context.Context, MID string, rejected bool) error {
+	return h.mbox.V2().SetSent(ctx, MID, rejected)
+}
+
+func (h *upstreamHandler) SetDeferred(ctx context.Context, MID string) error {
+	return h.mbox.V2().SetDeferred(ctx, MID)
+}
+
+// ProcessInbound routes the messages received from upstream to the local recipients.
+func (h *upstreamHandler) ProcessInbound(msgs ...*fbb.Message) error {
+	return h.hub.route(true, msgs...)
+}
+
+func (h *upstreamHandler) GetInboundAnswer(p fbb.Proposal) fbb.ProposalAnswer {
+	if h.hub.hasMessage(p.MID()) {
+		return fbb.Reject
+	}
+	return fbb.Accept
+}
+
+func (h *upstreamHandler) GetPartial(MID string) []byte {
+	return h.mbox.GetPartial(MID)
+}
+
+func (h *upstreamHandler) SetPartial(MID string, data []byte) error {
+	return h.mbox.SetPartial(MID, data)
+}
+
+func (h *upstreamHandler) DeletePartial(MID string) error {
+	return h.mbox.DeletePartial(MID)
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package hub implements a multi-station store-and-forward hub (a "radio-only hub").
+//
+// The hub accepts B2F sessions from many stations over any net.Listener (e.g. telnet.Listen or
+// an ARDOP listener) and keeps a mailbox for every station (see mailbox.UserPath). Messages
+// addressed to local stations are routed directly to the recipient's mailbox, while the rest is
+// queued in the hub's own mailbox and forwarded upstream to a CMS on a schedule.
+//
+// Mailbox layout (one mailbox.DirHandler per call sign):
+//
+//	<root>/<HUBCALL>/out  Messages waiting to be forwarded upstream.
+//	<root>/<HUBCALL>/in   Messages addressed to the hub itself.
+//	<root>/<CALL>/out     Messages waiting to be picked up by the station.
+//	<root>/<CALL>/in      Messages received from the station.
+package hub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/mailbox"
+)
+
+// callsignRe matches the call signs given a mailbox at the hub: a base call with an optional SSID.
+var callsignRe = regexp.MustCompile(`^[A-Z0-9]{1,10}(-[0-9]{1,2})?$`)
+
+// validCallsign returns true if the given call sign can be given a mailbox at the hub.
+//
+// The call signs are taken from the remote (login call and recipient addresses), and are used as
+// directory names. Anything but a plain call sign (e.g. "..", "A/B" or a network address) is refused.
+func validCallsign(callsign string) bool { return callsignRe.MatchString(strings.ToUpper(callsign)) }
+
+// Hub is a store-and-forward hub for multiple stations.
+type Hub struct {
+	mycall  string
+	locator string
+	root    string
+
+	passwordLookup fbb.PasswordLookup
+	upstream       *Upstream
+	spoolDir       string
+	spoolThreshold int
+
+	mu  sync.Mutex // Serializes routing of messages
+	log *log.Logger
+}
+
+// New returns a new Hub with the mailboxes stored in the given root directory.
+func New(mycall, locator, root string) *Hub {
+	return &Hub{
+		mycall:  strings.ToUpper(mycall),
+		locator: locator,
+		root:    root,
+		log:     fbb.StdLogger,
+
+		spoolThreshold: -1,
+	}
+}
+
+// SetLogger sets the logger used by the hub and its sessions.
+func (h *Hub) SetLogger(logger *log.Logger) {
+	if logger == nil {
+		logger = fbb.StdLogger
+	}
+	h.log = logger
+}
+
+// SetPasswordLookup enables secure login of the connecting stations.
+//
+// Without a PasswordLookup, any connecting station is able to pick up the messages of any
+// local station by requesting them as auxiliary addresses.
+func (h *Hub) SetPasswordLookup(l fbb.PasswordLookup) { h.passwordLookup = l }
+
+// SetSpool enables spooling of large inbound attachments to disk in all sessions (see fbb.Session.SetSpool).
+//
+// Attachments larger than threshold bytes are written to files in dir while received, instead of being
+// held in memory. A negative threshold disables spooling (default).
+func (h *Hub) SetSpool(dir string, threshold int) { h.spoolDir, h.spoolThreshold = dir, threshold }
+
+// AddStation creates a mailbox for the given call sign, making it a local station.
+//
+// Stations are also added when they connect to the hub for the first time. An error is returned
+// if the call sign is not valid (a base call with an optional SSID).
+func (h *Hub) AddStation(callsign string) error {
+	_, err := h.mailbox(callsign)
+	return err
+}
+
+// IsLocal returns true if the given call sign is the hub itself or a local station (has a mailbox at the hub).
+func (h *Hub) IsLocal(callsign string) bool {
+	if !validCallsign(callsign) {
+		return false
+	}
+	info, err := os.Stat(mailbox.UserPath(h.root, strings.ToUpper(callsign)))
+	return err == nil && info.IsDir()
+}
+
+// Stations returns the call signs of all local stations (excluding the hub itself).
+func (h *Hub) Stations() []string {
+	files, err := ioutil.ReadDir(h.root)
+	if err != nil {
+		return nil
+	}
+
+	calls := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() && f.Name() != h.mycall && f.Name()[0] != '.' {
+			calls = append(calls, f.Name())
+		}
+	}
+	return calls
+}
+
+// Serve accepts incoming connections on ln, and exchanges messages with the connecting stations.
+//
+// The remote's call sign is taken from the connection's RemoteCall method if available
+// (e.g. telnet), or the remote address (e.g. ARDOP and AX.25). Connections from anything but a
+// valid call sign (e.g. a network address) are refused.
+//
+// Serve always returns a non-nil error (from ln.Accept).
+func (h *Hub) Serve(ln net.Listener) error {
+	srv := &fbb.Server{
+		Mycall:  h.mycall,
+		Locator: h.locator,
+		Logger:  h.log,
+		Handler: func(call string) (fbb.MBoxHandlerV2, error) {
+			if !validCallsign(call) {
+				return nil, fmt.Errorf("Invalid call sign %q", call)
+			}
+			return &stationHandler{hub: h, call: strings.ToUpper(call)}, nil
+		},
+		Configure: func(s *fbb.Session, conn net.Conn) net.Conn {
+			if h.passwordLookup != nil {
+				s.SetPasswordLookup(h.passwordLookup)
+			}
+			s.SetSpool(h.spoolDir, h.spoolThreshold)
+			return conn
+		},
+	}
+	return srv.Serve(ln)
+}
+
+// Route delivers one or more messages to the mailboxes of their recipients.
+//
+// Messages addressed to local stations (or the hub) are delivered to their mailboxes,
+// and messages with one or more non-local recipients are queued for upstream forwarding.
+func (h *Hub) Route(msgs ...*fbb.Message) error {
+	return h.route(false, msgs...)
+}
+
+// route delivers the messages to local recipients, and queues them for upstream forwarding if
+// one or more recipients is not local. Messages received from upstream are never sent upstream again.
+func (h *Hub) route(fromUpstream bool, msgs ...*fbb.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, msg := range msgs {
+		var forward bool
+		for _, addr := range msg.Receivers() {
+			switch {
+			case addr.Proto != "" || !h.IsLocal(addr.Addr):
+				forward = true
+			case addr.Addr == h.mycall:
+				mbox, err := h.mailbox(h.mycall)
+				if err != nil {
+					return err
+				}
+				if err := mbox.ProcessInbound(msg); err != nil {
+					return err
+				}
+			default:
+				h.log.Printf("Routing %s to local station %s", msg.MID(), addr.Addr)
+				mbox, err := h.mailbox(addr.Addr)
+				if err == nil {
+					err = mbox.AddOut(msg)
+				}
+				if err != nil {
+					return fmt.Errorf("Unable to deliver %s to %s: %s", msg.MID(), addr.Addr, err)
+				}
+			}
+		}
+
+		switch {
+		case !forward:
+		case fromUpstream:
+			h.log.Printf("Ignoring non-local recipients of %s received from upstream", msg.MID())
+		default:
+			h.log.Printf("Queuing %s for upstream forwarding", msg.MID())
+			mbox, err := h.mailbox(h.mycall)
+			if err == nil {
+				err = mbox.AddOut(msg)
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to queue %s for upstream forwarding: %s", msg.MID(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// hasMessage returns true if the message identified by MID exists in any of the hub's mailboxes.
+//
+// MIDs that can't be used as file names are reported as existing, so that they are rejected.
+func (h *Hub) hasMessage(MID string) bool {
+	if fbb.ValidateMID(MID) != nil {
+		return true
+	}
+	for _, call := range append(h.Stations(), h.mycall) {
+		for _, dir := range []string{mailbox.DIR_INBOX, mailbox.DIR_OUTBOX, mailbox.DIR_SENT} {
+			if _, err := os.Stat(path.Join(mailbox.UserPath(h.root, call), dir, MID+mailbox.Ext)); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mailbox returns a new (prepared) handler for the mailbox of the given call sign.
+func (h *Hub) mailbox(callsign string) (*mailbox.DirHandler, error) {
+	if !validCallsign(callsign) {
+		return nil, fmt.Errorf("Invalid call sign %q", callsign)
+	}
+	mbox := mailbox.NewDirHandler(mailbox.UserPath(h.root, strings.ToUpper(callsign)), false)
+	return mbox, mbox.Prepare()
+}
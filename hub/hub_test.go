@@ -0,0 +1,236 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package hub
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/fbb/cmstest"
+	"github.com/la5nta/wl2k-go/mailbox"
+	"github.com/la5nta/wl2k-go/transport/telnet"
+)
+
+const (
+	hubCall  = "LA1HUB"
+	locator  = "JO39EQ"
+	password = "secret"
+)
+
+var discardLogger = log.New(ioutil.Discard, "", 0)
+
+func TestHub(t *testing.T) {
+	h, ln := newTestHub(t)
+	defer ln.Close()
+
+	if err := h.AddStation("LA1B"); err != nil {
+		t.Fatal(err)
+	}
+
+	// LA1A sends one message to a local station and one to a non-local station
+	local := cmstest.NewMessage("LA1A", "LA1B")
+	remote := cmstest.NewMessage("LA1A", "LA5NTA")
+	mboxA := cmstest.NewMBox(local, remote)
+	if err := exchange(ln, "LA1A", mboxA); err != nil {
+		t.Fatalf("Exchange with LA1A failed: %s", err)
+	}
+	if len(mboxA.Outbox()) != 0 {
+		t.Errorf("LA1A: Outbound messages not sent")
+	}
+	if !h.IsLocal("LA1A") {
+		t.Errorf("LA1A not added as local station on connect")
+	}
+	if mids := outbox(t, h, "LA1B"); len(mids) != 1 || mids[0] != local.MID() {
+		t.Errorf("LA1B: Unexpected pending messages: %v", mids)
+	}
+	if mids := outbox(t, h, hubCall); len(mids) != 1 || mids[0] != remote.MID() {
+		t.Errorf("Unexpected upstream queue: %v", mids)
+	}
+
+	// Duplicates are rejected
+	mboxA = cmstest.NewMBox(local)
+	if err := exchange(ln, "LA1A", mboxA); err != nil {
+		t.Fatalf("Exchange with LA1A failed: %s", err)
+	}
+	if mids := outbox(t, h, "LA1B"); len(mids) != 1 {
+		t.Errorf("LA1B: Unexpected pending messages: %v", mids)
+	}
+
+	// LA1B picks up the message
+	mboxB := cmstest.NewMBox()
+	if err := exchange(ln, "LA1B", mboxB); err != nil {
+		t.Fatalf("Exchange with LA1B failed: %s", err)
+	}
+	msg, ok := mboxB.Inbox()[local.MID()]
+	if !ok {
+		t.Fatalf("LA1B: Message not received")
+	}
+	if msg.Header.Get("X-FilePath") != "" {
+		t.Errorf("LA1B: Private header delivered to station")
+	}
+	if mids := outbox(t, h, "LA1B"); len(mids) != 0 {
+		t.Errorf("LA1B: Delivered message still pending: %v", mids)
+	}
+}
+
+func TestInvalidCallsigns(t *testing.T) {
+	h, ln := newTestHub(t)
+	defer ln.Close()
+
+	for _, call := range []string{"..", "../LA1A", `LA1A\x`, "", "127.0.0.1:1234"} {
+		if err := h.AddStation(call); err == nil {
+			t.Errorf("Expected error adding station %q", call)
+		}
+		if h.IsLocal(call) {
+			t.Errorf("%q reported as local station", call)
+		}
+	}
+
+	// A recipient outside the hub root is forwarded upstream, not delivered
+	base, err := ioutil.TempDir("", "hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	nested := New(hubCall, locator, filepath.Join(base, "root"))
+	nested.SetLogger(discardLogger)
+
+	msg := cmstest.NewMessage("LA1A", "..")
+	if err := nested.Route(msg); err != nil {
+		t.Fatal(err)
+	}
+	if mids := outbox(t, nested, hubCall); len(mids) != 1 || mids[0] != msg.MID() {
+		t.Errorf("Unexpected upstream queue: %v", mids)
+	}
+	if files, _ := ioutil.ReadDir(base); len(files) != 1 {
+		t.Errorf("Files created outside of hub root: %v", files)
+	}
+
+	// Stations connecting with an invalid call sign are refused
+	if err := exchange(ln, "../LA1A", cmstest.NewMBox()); err == nil {
+		t.Errorf("Expected exchange with invalid call sign to fail")
+	}
+	if stations := h.Stations(); len(stations) != 0 {
+		t.Errorf("Unexpected stations %v", stations)
+	}
+}
+
+func TestForwardUpstream(t *testing.T) {
+	h, ln := newTestHub(t)
+	ln.Close()
+
+	if _, err := h.ForwardUpstream(context.Background()); err != ErrNoUpstream {
+		t.Errorf("Expected ErrNoUpstream, got %v", err)
+	}
+
+	srv, err := cmstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.SetPassword(hubCall, password)
+	srv.SetPassword("LA1A", password)
+
+	h.SetUpstream(Upstream{
+		Targetcall:            telnet.CMSTargetCall,
+		Dial:                  func() (net.Conn, error) { return telnet.Dial(srv.Addr(), hubCall, telnet.CMSPassword) },
+		SecureLoginHandleFunc: func(fbb.Address) (string, error) { return password, nil },
+	})
+
+	if err := h.AddStation("LA1A"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := cmstest.NewMessage("LA1A", "LA5NTA")
+	if err := h.Route(out); err != nil {
+		t.Fatal(err)
+	}
+	in := cmstest.NewMessage("LA5NTA", "LA1A")
+	srv.Mailbox("LA1A").Add(in)
+
+	if _, err := h.ForwardUpstream(context.Background()); err != nil {
+		t.Fatalf("Upstream forwarding failed: %s", err)
+	}
+
+	if pending := srv.Mailbox("LA5NTA").Pending(); len(pending) != 1 || pending[0].MID() != out.MID() {
+		t.Errorf("Queued message not forwarded upstream")
+	}
+	if mids := outbox(t, h, hubCall); len(mids) != 0 {
+		t.Errorf("Forwarded message still queued: %v", mids)
+	}
+	if mids := outbox(t, h, "LA1A"); len(mids) != 1 || mids[0] != in.MID() {
+		t.Errorf("LA1A: Unexpected pending messages: %v", mids)
+	}
+	if len(srv.Mailbox("LA1A").Pending()) != 0 {
+		t.Errorf("Received message still pending upstream")
+	}
+	if errs := srv.Errors(); len(errs) > 0 {
+		t.Errorf("Unexpected server errors: %v", errs)
+	}
+
+	// An aborted exchange leaves the queue untouched
+	if err := h.Route(cmstest.NewMessage("LA1A", "LA5NTA")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := h.ForwardUpstream(ctx); err == nil {
+		t.Errorf("Expected error from aborted upstream forwarding")
+	}
+	if mids := outbox(t, h, hubCall); len(mids) != 1 {
+		t.Errorf("Unexpected upstream queue after abort: %v", mids)
+	}
+}
+
+func newTestHub(t *testing.T) (*Hub, net.Listener) {
+	root, err := ioutil.TempDir("", "hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	ln, err := telnet.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := New(hubCall, locator, root)
+	h.SetLogger(discardLogger)
+	go h.Serve(ln)
+	return h, ln
+}
+
+// exchange connects to the hub as mycall and exchanges messages with mbox.
+func exchange(ln net.Listener, mycall string, mbox fbb.MBoxHandler) error {
+	conn, err := telnet.Dial(ln.Addr().String(), mycall, "")
+	if err != nil {
+		return err
+	}
+
+	s := fbb.NewSession(mycall, hubCall, locator, mbox)
+	s.SetLogger(discardLogger)
+	_, err = s.Exchange(conn)
+	return err
+}
+
+// outbox returns the MIDs of the messages in the outbox of the given call sign.
+func outbox(t *testing.T, h *Hub, callsign string) []string {
+	msgs, err := mailbox.NewDirHandler(mailbox.UserPath(h.root, callsign), false).Outbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		mids = append(mids, msg.MID())
+	}
+	return mids
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package hub
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+// ErrNoUpstream is returned when upstream forwarding is attempted without an Upstream.
+var ErrNoUpstream = errors.New("No upstream configured")
+
+// Upstream holds the configuration used to forward messages to and from a CMS.
+type Upstream struct {
+	// Targetcall is the call sign of the upstream node (e.g. telnet.CMSTargetCall).
+	Targetcall string
+
+	// Dial connects to the upstream node (e.g. telnet.DialCMS).
+	Dial func() (net.Conn, error)
+
+	// SecureLoginHandleFunc provides the secure login password of the hub and every local
+	// station (see fbb.Session.SetSecureLoginHandleFunc). If the password of a station is
+	// unavailable, its messages can't be requested from upstream.
+	SecureLoginHandleFunc func(addr fbb.Address) (password string, err error)
+}
+
+// SetUpstream sets the upstream node used to forward messages.
+func (h *Hub) SetUpstream(u Upstream) { h.upstream = &u }
+
+// ForwardUpstream connects to the upstream node, sending all queued messages and
+// requesting messages on behalf of the hub and all local stations.
+//
+// The exchange is aborted if ctx is done before it completes (see fbb.Session.ExchangeContext).
+func (h *Hub) ForwardUpstream(ctx context.Context) (fbb.TrafficStats, error) {
+	if h.upstream == nil {
+		return fbb.TrafficStats{}, ErrNoUpstream
+	}
+
+	conn, err := h.upstream.Dial()
+	if err != nil {
+		return fbb.TrafficStats{}, err
+	}
+
+	session := fbb.NewSessionV2(h.mycall, h.upstream.Targetcall, h.locator, &upstreamHandler{hub: h})
+	session.SetLogger(h.log)
+	session.SetSpool(h.spoolDir, h.spoolThreshold)
+	if h.upstream.SecureLoginHandleFunc != nil {
+		session.SetSecureLoginHandleFunc(h.upstream.SecureLoginHandleFunc)
+	}
+	for _, call := range h.Stations() {
+		session.AddAuxiliaryAddress(fbb.AddressFromString(call))
+	}
+
+	return session.ExchangeContext(ctx, conn)
+}
+
+// RunUpstream forwards messages upstream right away and then every interval, until ctx is done.
+// An exchange in progress when ctx is done is aborted.
+//
+// Failed attempts are logged and retried at the next interval. RunUpstream returns ctx.Err().
+func (h *Hub) RunUpstream(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if stats, err := h.ForwardUpstream(ctx); err != nil {
+			h.log.Printf("Upstream forwarding failed: %s", err)
+		} else {
+			h.log.Printf("Upstream forwarding done (%d sent, %d received)", len(stats.Sent), len(stats.Received))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mailbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BIDHistory is a file backed fbb.BIDStore.
+//
+// The file holds one BID per line, followed by the time (unix) it was added.
+// Entries older than the history's max age are discarded when the file is opened.
+type BIDHistory struct {
+	mu   sync.Mutex
+	path string
+	seen map[string]time.Time
+}
+
+// OpenBIDHistory opens (or creates) the BID history stored in the file given by path.
+//
+// BIDs older than maxAge are discarded (0 means never).
+func OpenBIDHistory(path string, maxAge time.Duration) (*BIDHistory, error) {
+	h := &BIDHistory{path: path, seen: make(map[string]time.Time)}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return h, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
+
+	var expired int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed BID history entry (%s): %s", path, scanner.Text())
+		}
+		if t := time.Unix(unix, 0); maxAge == 0 || time.Since(t) < maxAge {
+			h.seen[fields[0]] = t
+		} else {
+			expired++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if expired > 0 {
+		return h, h.compact()
+	}
+	return h, nil
+}
+
+// HasBID returns true if the given BID is in the history.
+func (h *BIDHistory) HasBID(BID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.seen[strings.ToUpper(BID)]
+	return ok
+}
+
+// AddBID adds the given BID to the history.
+func (h *BIDHistory) AddBID(BID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	BID = strings.ToUpper(BID)
+	if _, ok := h.seen[BID]; ok {
+		return nil
+	}
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	if _, err := fmt.Fprintf(f, "%s %d\n", BID, now.Unix()); err != nil {
+		return err
+	}
+	h.seen[BID] = now
+	return nil
+}
+
+// compact rewrites the history file, leaving out the discarded entries.
+func (h *BIDHistory) compact() error {
+	tmp := h.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for BID, t := range h.seen {
+		fmt.Fprintf(w, "%s %d\n", BID, t.Unix())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
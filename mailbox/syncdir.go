@@ -6,6 +6,7 @@
 package mailbox
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -13,7 +14,9 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/la5nta/wl2k-go/fbb"
 )
@@ -23,10 +26,22 @@ const (
 	DIR_OUTBOX  = "/out/"
 	DIR_SENT    = "/sent/"
 	DIR_ARCHIVE = "/archive/"
+	DIR_PARTIAL = "/partial/"
 )
 
 const Ext = ".b2f"
 
+// The private header holding the priority of outbound messages (see SetPriority).
+//
+// Not to be confused with the X-Priority email header, where 1 is the highest priority.
+const headerPriority = "X-Wl2k-Priority"
+
+// The private header holding the delivery confirmations of sent messages (see Deliveries).
+const headerDelivered = "X-Delivered"
+
+// PartialExt is the file extension used for partially received messages.
+const PartialExt = ".part"
+
 // NewDirHandler is a file system (directory) oriented mailbox handler.
 type DirHandler struct {
 	MBoxPath string
@@ -71,13 +86,16 @@ func (h *DirHandler) OutboxCount() int  { return countFiles(path.Join(h.MBoxPath
 func (h *DirHandler) SentCount() int    { return countFiles(path.Join(h.MBoxPath, DIR_SENT)) }
 func (h *DirHandler) ArchiveCount() int { return countFiles(path.Join(h.MBoxPath, DIR_ARCHIVE)) }
 
+// AddOut adds the message to the outbox.
+//
+// The message's priority (see fbb.Message.SetPriority) is kept in the private X-Wl2k-Priority header.
 func (h *DirHandler) AddOut(msg *fbb.Message) error {
-	data, err := msg.Bytes()
-	if err != nil {
-		return err
+	if msg.Priority() != 0 {
+		msg.Header.Set(headerPriority, strconv.Itoa(int(msg.Priority())))
+		defer msg.Header.Del(headerPriority)
 	}
 
-	return ioutil.WriteFile(path.Join(h.MBoxPath, DIR_OUTBOX, msg.MID()+Ext), data, 0644)
+	return writeMessage(path.Join(h.MBoxPath, DIR_OUTBOX, msg.MID()+Ext), msg, 0644)
 }
 
 func (h *DirHandler) ProcessInbound(msgs ...*fbb.Message) (err error) {
@@ -87,12 +105,7 @@ func (h *DirHandler) ProcessInbound(msgs ...*fbb.Message) (err error) {
 
 		m.Header.Set("X-Unread", "true")
 
-		data, err := m.Bytes()
-		if err != nil {
-			return err
-		}
-
-		if err = ioutil.WriteFile(filename, data, 0664); err != nil {
+		if err = writeMessage(filename, m, 0664); err != nil {
 			return fmt.Errorf("Unable to write received message (%s): %s", filename, err)
 		}
 	}
@@ -118,24 +131,86 @@ func (h *DirHandler) GetInboundAnswer(p fbb.Proposal) fbb.ProposalAnswer {
 	return fbb.Accept
 }
 
+// SetSent moves the message to the sent folder. Errors are logged (see DirHandlerV2).
 func (h *DirHandler) SetSent(MID string, rejected bool) {
+	if err := h.setSent(MID); err != nil {
+		log.Println(err)
+	}
+}
+
+func (h *DirHandler) setSent(MID string) error {
 	oldPath := path.Join(h.MBoxPath, DIR_OUTBOX, MID+Ext)
 	newPath := path.Join(h.MBoxPath, DIR_SENT, MID+Ext)
 
 	if err := os.Rename(oldPath, newPath); err != nil {
-		log.Fatalf("Unable to move %s to %s: %s", oldPath, newPath, err)
+		return fmt.Errorf("Unable to move %s to %s: %s", oldPath, newPath, err)
 	}
+	return nil
 }
 
 func (h *DirHandler) SetDeferred(MID string) {
 	h.deferred[MID] = true
 }
 
+// GetPartial returns the partially received (compressed) data for the given MID, if any.
+func (h *DirHandler) GetPartial(MID string) []byte {
+	filePath, err := h.partialPath(MID)
+	if err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to read partially received message %s: %s", MID, err)
+	}
+	return data
+}
+
+// SetPartial saves partially received (compressed) data for the given MID.
+func (h *DirHandler) SetPartial(MID string, data []byte) error {
+	filePath, err := h.partialPath(MID)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, data, 0664)
+}
+
+// DeletePartial removes any partially received data for the given MID.
+func (h *DirHandler) DeletePartial(MID string) error {
+	filePath, err := h.partialPath(MID)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// partialPath returns the path of the partially received data for the given MID.
+//
+// The MID is given by the remote, so it's validated before use as a file name.
+func (h *DirHandler) partialPath(MID string) (string, error) {
+	if err := fbb.ValidateMID(MID); err != nil {
+		return "", fmt.Errorf("Invalid MID %q: %w", MID, err)
+	}
+	return path.Join(h.MBoxPath, DIR_PARTIAL, MID+PartialExt), nil
+}
+
+// GetOutbound returns the outbound messages. Errors are logged (see DirHandlerV2).
 func (h *DirHandler) GetOutbound(fws ...fbb.Address) []*fbb.Message {
-	all, err := LoadMessageDir(path.Join(h.MBoxPath, DIR_OUTBOX))
+	msgs, err := h.getOutbound(fws...)
 	if err != nil {
 		log.Println(err)
 	}
+	return msgs
+}
+
+func (h *DirHandler) getOutbound(fws ...fbb.Address) ([]*fbb.Message, error) {
+	all, err := LoadMessageDir(path.Join(h.MBoxPath, DIR_OUTBOX))
+	if err != nil {
+		return nil, err
+	}
 
 	deliver := make([]*fbb.Message, 0, len(all))
 	for _, m := range all {
@@ -143,6 +218,12 @@ func (h *DirHandler) GetOutbound(fws ...fbb.Address) []*fbb.Message {
 			continue
 		}
 
+		// The priority is local metadata
+		if p, err := strconv.Atoi(m.Header.Get(headerPriority)); err == nil {
+			m.SetPriority(fbb.Priority(p))
+		}
+		m.Header.Del(headerPriority)
+
 		// Check unsent messages that are addressed to one of the
 		// forwarder addresses of the remote.
 		if len(fws) > 0 {
@@ -166,7 +247,52 @@ func (h *DirHandler) GetOutbound(fws ...fbb.Address) []*fbb.Message {
 
 		deliver = append(deliver, m)
 	}
-	return deliver
+	return deliver, nil
+}
+
+// SetDelivered records the delivery confirmation in the private X-Delivered header of the sent message (see Deliveries).
+func (h *DirHandler) SetDelivered(ack fbb.Acknowledgement) error {
+	filePath := path.Join(h.MBoxPath, DIR_SENT, ack.MID+Ext)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		filePath = path.Join(h.MBoxPath, DIR_ARCHIVE, ack.MID+Ext) // Archived before the confirmation arrived
+	}
+
+	msg, err := OpenMessage(filePath)
+	if err != nil {
+		return err
+	}
+	msg.Header.Del("X-FilePath")
+	msg.Header.Add(headerDelivered, fmt.Sprintf("%s %s", ack.Recipient, ack.Delivered.UTC().Format(fbb.DateLayout)))
+
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, data, 0644)
+}
+
+var _ fbb.AckHandler = (*DirHandler)(nil)
+
+// DirHandlerV2 is a DirHandler implementing the fbb.MBoxHandlerV2 interface, reporting errors from
+// the outbound operations to the session instead of logging them.
+type DirHandlerV2 struct{ *DirHandler }
+
+var _ fbb.MBoxHandlerV2 = DirHandlerV2{}
+
+// V2 returns the handler as a fbb.MBoxHandlerV2 (see fbb.NewSessionV2).
+func (h *DirHandler) V2() DirHandlerV2 { return DirHandlerV2{h} }
+
+func (h DirHandlerV2) GetOutbound(ctx context.Context, fws ...fbb.Address) ([]*fbb.Message, error) {
+	return h.getOutbound(fws...)
+}
+
+func (h DirHandlerV2) SetSent(ctx context.Context, MID string, rejected bool) error {
+	return h.setSent(MID)
+}
+
+func (h DirHandlerV2) SetDeferred(ctx context.Context, MID string) error {
+	h.DirHandler.SetDeferred(MID)
+	return nil
 }
 
 func DefaultMailboxPath() (string, error) {
@@ -195,6 +321,8 @@ func ensureDirStructure(mboxPath string) (err error) {
 		return
 	} else if err = os.MkdirAll(path.Join(mboxPath, DIR_ARCHIVE), mode); err != nil {
 		return
+	} else if err = os.MkdirAll(path.Join(mboxPath, DIR_PARTIAL), mode); err != nil {
+		return
 	}
 	return
 }
@@ -270,6 +398,26 @@ func OpenMessage(path string) (*fbb.Message, error) {
 	return message, nil
 }
 
+// writeMessage writes the message to the named file.
+//
+// The message is written directly to the file, so that attachments not held in memory
+// (see fbb.NewFileFromPath) are never read into memory.
+func writeMessage(filename string, msg *fbb.Message, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	err = msg.Write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename) // Don't leave a partial message behind
+	}
+	return err
+}
+
 // IsUnread returns true if the given message is marked as unread.
 func IsUnread(msg *fbb.Message) bool { return msg.Header.Get("X-Unread") == "true" }
 
@@ -296,3 +444,48 @@ func SetUnread(msg *fbb.Message, unread bool) error {
 	}
 	return ioutil.WriteFile(filePath, data, 0644)
 }
+
+// Deliveries returns the delivery confirmations received for the given sent message.
+//
+// Confirmations are only received from remotes supporting acknowledgements (see fbb.AckHandler).
+func Deliveries(msg *fbb.Message) []fbb.Acknowledgement {
+	var acks []fbb.Acknowledgement
+	for _, v := range msg.Header[headerDelivered] {
+		parts := strings.SplitN(v, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		t, err := time.Parse(fbb.DateLayout, parts[1])
+		if err != nil {
+			continue
+		}
+		acks = append(acks, fbb.Acknowledgement{MID: msg.MID(), Recipient: fbb.AddressFromString(parts[0]), Delivered: t})
+	}
+	return acks
+}
+
+// SetPriority sets the priority of the given outbound message and re-writes the file to disk.
+//
+// The priority is used to order outbound messages (see fbb.OrderByPriority), and is not
+// transferred with the message.
+func SetPriority(msg *fbb.Message, p fbb.Priority) error {
+	filePath := msg.Header.Get("X-FilePath")
+	if filePath == "" {
+		return fmt.Errorf("Missing X-FilePath header")
+	}
+
+	msg.SetPriority(p)
+	if p != 0 {
+		msg.Header.Set(headerPriority, strconv.Itoa(int(p)))
+	} else {
+		msg.Header.Del(headerPriority)
+	}
+
+	msg.Header.Del("X-FilePath")
+	data, err := msg.Bytes()
+	msg.Header.Set("X-FilePath", filePath)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, data, 0644)
+}
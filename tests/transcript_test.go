@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/fbb/cmstest"
+	"github.com/la5nta/wl2k-go/fbb/transcript"
+)
+
+// The transcripts in the corpus are seen from N0CALL connecting to LA1B (session master).
+//
+// Transcripts prefixed by synthetic_ are not recorded from RMS Express, CMS or Radio Only
+// gateways. They are generated with package transcript to reproduce quirks handled by
+// package fbb, and should be replaced by real recordings when available.
+const (
+	transcriptMycall     = "N0CALL"
+	transcriptTargetcall = "LA1B"
+	transcriptLocator    = "JO39EQ"
+)
+
+var transcriptTests = map[string]struct {
+	outbound []*fbb.Message
+	verify   func(t *testing.T, mbox *cmstest.MBox)
+}{
+	// CMS sends MTD stats prefixed by *** during handshake. This is not an error.
+	"synthetic_cms_mtd_stats.txt": {},
+
+	// Radio Only gateways sometimes propose the same MID twice in the same batch.
+	// The duplicate should be deferred, while the first one is received.
+	"synthetic_duplicate_mid.txt": {
+		verify: func(t *testing.T, mbox *cmstest.MBox) {
+			if n := len(mbox.Inbox()); n != 1 {
+				t.Errorf("Expected 1 message in inbox, got %d", n)
+			}
+		},
+	},
+
+	// RMS Express (Winmor P2P) may request an offset exceeding the protocol's limit. The offset should be ignored.
+	"synthetic_rms_express_offset_limit.txt": {
+		outbound: []*fbb.Message{transcriptMessage()},
+		verify:   verifyOutboxEmpty,
+	},
+
+	// RMS Express requests an offset when resuming an interrupted transfer.
+	"synthetic_rms_express_offset.txt": {
+		outbound: []*fbb.Message{transcriptMessage()},
+		verify:   verifyOutboxEmpty,
+	},
+}
+
+func TestTranscripts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("transcripts", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		test, ok := transcriptTests[name]
+		if !ok {
+			t.Errorf("%s: Missing test definition", name)
+			continue
+		}
+		seen[name] = true
+
+		t.Run(strings.TrimSuffix(name, ".txt"), func(t *testing.T) {
+			tr, err := transcript.Load(path)
+			if err != nil {
+				t.Fatalf("Unable to load transcript: %s", err)
+			}
+
+			mbox := cmstest.NewMBox(test.outbound...)
+			conn := transcript.Replay(tr)
+			s := fbb.NewSession(transcriptMycall, transcriptTargetcall, transcriptLocator, mbox)
+			if _, err := s.Exchange(conn); err != nil {
+				t.Fatalf("Exchange failed: %s", err)
+			}
+			if err := conn.Err(); err != nil {
+				t.Fatalf("Replay failed: %s", err)
+			}
+			if !conn.Done() {
+				t.Errorf("Exchange ended before the end of the transcript")
+			}
+			if test.verify != nil {
+				test.verify(t, mbox)
+			}
+		})
+	}
+
+	for name := range transcriptTests {
+		if seen[name] {
+			continue
+		}
+		t.Errorf("%s: Transcript not found", name)
+	}
+}
+
+func verifyOutboxEmpty(t *testing.T, mbox *cmstest.MBox) {
+	if n := len(mbox.Outbox()); n != 0 {
+		t.Errorf("Expected empty outbox, got %d messages", n)
+	}
+}
+
+// transcriptMessage returns the outbound message used when recording the transcripts.
+func transcriptMessage() *fbb.Message {
+	msg := fbb.NewMessage(fbb.Private, transcriptMycall)
+	msg.Header.Set(fbb.HEADER_MID, "TRANSCRIPT01")
+	msg.SetDate(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+	msg.AddTo(transcriptTargetcall)
+	msg.SetSubject("Transcript test")
+	msg.SetBody(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40))
+	return msg
+}
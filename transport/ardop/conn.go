@@ -14,6 +14,8 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 type tncConn struct {
@@ -191,6 +193,11 @@ func (conn *tncConn) Close() error {
 	}
 }
 
+// OptimalWriteSize returns the preferred maximum number of bytes per write.
+//
+// Every write is framed as a separate data block to the TNC, so fewer (larger) writes are preferred.
+func (conn *tncConn) OptimalWriteSize() int { return transport.StreamWriteSize }
+
 // TxBufferLen returns the number of bytes in the out buffer queue.
 func (conn *tncConn) TxBufferLen() int {
 	conn.mu.Lock()
@@ -70,8 +70,14 @@ type Conn struct {
 	io.ReadWriteCloser
 	localAddr  AX25Addr
 	remoteAddr AX25Addr
+	paclen     int // Maximum packet length (0 if unknown)
 }
 
+// OptimalWriteSize returns the maximum packet length (paclen) of the connection.
+//
+// Zero is returned if the packet length is unknown.
+func (c *Conn) OptimalWriteSize() int { return c.paclen }
+
 func (c *Conn) LocalAddr() net.Addr {
 	if !c.ok() {
 		return nil
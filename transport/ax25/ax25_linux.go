@@ -31,7 +31,8 @@ type ax25Addr C.struct_full_sockaddr_ax25
 var numAXPorts int
 
 // bug(martinhpedersen): The AX.25 stack does not support SOCK_STREAM, so any write to the connection
-// that is larger than maximum packet length will fail. The b2f impl. requires 125 bytes long packets.
+// that is larger than maximum packet length will fail. The b2f impl. adapts to the port's paclen
+// (see Conn.OptimalWriteSize), but some handshake lines requires >= 125 bytes long packets.
 var ErrMessageTooLong = errors.New("Write: Message too long. Consider increasing maximum packet length to >= 125.")
 var ErrPortNotExist = errors.New("No such AX port found")
 
@@ -40,11 +41,15 @@ type fd uintptr
 type ax25Listener struct {
 	sock      fd
 	localAddr AX25Addr
+	paclen    int
 	close     chan struct{}
 }
 
 func portExists(port string) bool { return C.ax25_config_get_dev(C.CString(port)) != nil }
 
+// portPaclen returns the maximum packet length of the given port as configured in axports.
+func portPaclen(port string) int { return int(C.ax25_config_get_paclen(C.CString(port))) }
+
 func loadPorts() (int, error) {
 	if numAXPorts > 0 {
 		return numAXPorts, nil
@@ -97,6 +102,7 @@ func (ln ax25Listener) Accept() (net.Conn, error) {
 	conn := &Conn{
 		localAddr:       ln.localAddr,
 		remoteAddr:      AX25Addr{addr},
+		paclen:          ln.paclen,
 		ReadWriteCloser: os.NewFile(uintptr(nfd), ""),
 	}
 
@@ -135,6 +141,7 @@ func ListenAX25(axPort, mycall string) (net.Listener, error) {
 	return ax25Listener{
 		sock:      fd(socket),
 		localAddr: AX25Addr{localAddr},
+		paclen:    portPaclen(axPort),
 		close:     make(chan struct{}),
 	}, nil
 }
@@ -176,6 +183,7 @@ func DialAX25Timeout(axPort, mycall, targetcall string, timeout time.Duration) (
 		ReadWriteCloser: os.NewFile(uintptr(socket), axPort),
 		localAddr:       AX25Addr{localAddr},
 		remoteAddr:      AX25Addr{remoteAddr},
+		paclen:          portPaclen(axPort),
 	}, nil
 }
 
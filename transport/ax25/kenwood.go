@@ -42,6 +42,7 @@ func DialKenwood(dev, mycall, targetcall string, config Config, logger *log.Logg
 	conn := &KenwoodConn{Conn{
 		localAddr:  AX25Addr{localAddr},
 		remoteAddr: AX25Addr{remoteAddr},
+		paclen:     int(config.PacketLength),
 	}}
 
 	if dev == "socket" {
@@ -16,6 +16,22 @@ type TxBuffer interface {
 	TxBufferLen() int
 }
 
+// A WriteSizer is a connection that knows the preferred size of each write.
+//
+// The fbb package uses this to size the data blocks of a message transfer.
+type WriteSizer interface {
+	// OptimalWriteSize returns the preferred maximum number of bytes per write
+	// (e.g. the maximum packet length of the link).
+	//
+	// A value <= 0 implies that the size is unknown.
+	OptimalWriteSize() int
+}
+
+// StreamWriteSize is the OptimalWriteSize of stream oriented connections without a packet length
+// limitation (e.g. TCP, or TNCs framing each write as a separate data block), where fewer and larger
+// writes are preferred.
+const StreamWriteSize = 4096
+
 type Robust interface {
 	// Enables/disables robust mode.
 	SetRobust(r bool) error
@@ -63,10 +63,14 @@ func (d Dialer) DialURL(url *transport.URL) (net.Conn, error) {
 	return DialTimeout(url.Host, user, pass, d.Timeout)
 }
 
+// Dial connects and logs in to the telnet server at addr.
+//
+// The returned net.Conn is a *Conn, which implements transport.WriteSizer.
 func Dial(addr, mycall, password string) (net.Conn, error) {
 	return DialTimeout(addr, mycall, password, 5*time.Second)
 }
 
+// DialTimeout acts like Dial, but takes a timeout for establishing the TCP connection.
 func DialTimeout(addr, mycall, password string, timeout time.Duration) (net.Conn, error) {
 	conn, err := net.DialTimeout(`tcp`, addr, timeout)
 	if err != nil {
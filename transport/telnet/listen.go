@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"net"
 	"strings"
+
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 type Conn struct {
@@ -18,6 +20,13 @@ type Conn struct {
 
 func (conn Conn) RemoteCall() string { return conn.remoteCall }
 
+// OptimalWriteSize returns the preferred maximum number of bytes per write.
+//
+// TCP has no packet length limitation, so this is only limited by the size of our write buffers.
+func (conn Conn) OptimalWriteSize() int { return transport.StreamWriteSize }
+
+var _ transport.WriteSizer = Conn{}
+
 type listener struct{ net.Listener }
 
 // Starts a new net.Listener listening for incoming connections.